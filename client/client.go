@@ -3,72 +3,238 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"github.com/Niiazgulov/tages.git/protos"
 	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
 )
 
 type imgClient struct {
-	service pb.ImageWorkerClient
+	service   pb.ImageWorkerClient
+	clientID  string
+	clientKey string
 }
 
 func NewImgWorkerClient(cc *grpc.ClientConn) *imgClient {
 	service := pb.NewImageWorkerClient(cc)
-	return &imgClient{service}
+	return &imgClient{service: service}
 }
 
-func (imgClient *imgClient) UploadImage(imagePath string, filename string) {
+// SetClientID makes the server account this client's requests under id instead of its network address.
+func (imgClient *imgClient) SetClientID(id string) {
+	imgClient.clientID = id
+}
+
+// SetClientKey sets the key proving the client id, which the server asks for before it
+// charges uploads to the namespace of the id.
+func (imgClient *imgClient) SetClientKey(key string) {
+	imgClient.clientKey = key
+}
+
+func (imgClient *imgClient) newContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(imgClient.outgoingContext(), timeout)
+}
+
+// outgoingContext returns a context carrying the client id and key, without a deadline.
+func (imgClient *imgClient) outgoingContext() context.Context {
+	ctx := context.Background()
+	if imgClient.clientID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, protos.ClientIDKey, imgClient.clientID)
+	}
+	if imgClient.clientKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, protos.ClientKeyKey, imgClient.clientKey)
+	}
+	return ctx
+}
+
+// uploadAttempts bounds how many times UploadImage resumes an interrupted upload.
+const uploadAttempts = 5
+
+// uploadStallTimeout is how long an upload stream may go without getting a chunk through, or
+// without the server's answer once all are sent, before it is given up and resumed.
+var uploadStallTimeout = time.Minute
+
+// UploadImage uploads the file at imagePath as filename through an upload session,
+// resuming from the last byte the server holds whenever the stream breaks.
+func (imgClient *imgClient) UploadImage(imagePath string, filename string, tags ...string) {
 	file, err := os.Open(imagePath)
 	if err != nil {
 		log.Fatal("cannot open image file: ", err)
 	}
 	defer file.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	header, err := describeImage(file, filename, tags)
+	if err != nil {
+		log.Fatal("cannot describe image file: ", err)
+	}
+
+	uploadID, err := imgClient.startUpload(header)
+	if err != nil {
+		log.Fatal("cannot start upload: ", err)
+	}
+
+	var offset int64
+	for attempt := 1; ; attempt++ {
+		res, err := imgClient.uploadFrom(file, uploadID, offset)
+		if err == nil && res.GetImageId() != "" {
+			log.Printf("image %s uploaded at: %s", filename, res.GetCreatedAt().AsTime().Local().Format(time.RFC850))
+			return
+		}
+		if err != nil && !retryable(err) {
+			log.Fatal("cannot upload image: ", err)
+		}
+		if attempt == uploadAttempts {
+			log.Fatalf("cannot upload image after %d attempts: %v", attempt, err)
+		}
+
+		log.Printf("upload of %s interrupted (attempt %d): %v; resuming", filename, attempt, err)
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+
+		st, err := imgClient.uploadStatus(uploadID)
+		if err != nil && !retryable(err) {
+			log.Fatal("cannot get upload status: ", err)
+		}
+		if err == nil {
+			offset = st.GetReceived()
+		}
+	}
+}
+
+func (imgClient *imgClient) startUpload(header *pb.ImageHeader) (string, error) {
+	ctx, cancel := imgClient.newContext(5 * time.Second)
+	defer cancel()
+
+	res, err := imgClient.service.StartUpload(ctx, &pb.StartUploadRequest{Header: header})
+	if err != nil {
+		return "", err
+	}
+
+	return res.GetUploadId(), nil
+}
+
+func (imgClient *imgClient) uploadStatus(uploadID string) (*pb.UploadStatusResponse, error) {
+	ctx, cancel := imgClient.newContext(5 * time.Second)
 	defer cancel()
 
+	return imgClient.service.GetUploadStatus(ctx, &pb.UploadStatusRequest{UploadId: uploadID})
+}
+
+// uploadFrom sends the file from offset to the end as one stream of the upload session.
+func (imgClient *imgClient) uploadFrom(file *os.File, uploadID string, offset int64) (*pb.UploadResponse, error) {
+	_, err := file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("cannot seek image file: %w", err)
+	}
+
+	// Images up to the size limit take long on a slow link, so the stream has no deadline of its
+	// own and is only given up once it stops making progress.
+	ctx, cancel := context.WithCancel(imgClient.outgoingContext())
+	defer cancel()
+	var stalled atomic.Bool
+	watchdog := time.AfterFunc(uploadStallTimeout, func() {
+		stalled.Store(true)
+		cancel()
+	})
+	defer watchdog.Stop()
+
+	res, err := imgClient.sendFrom(ctx, file, uploadID, offset, func() { watchdog.Reset(uploadStallTimeout) })
+	if err != nil && stalled.Load() {
+		return nil, status.Errorf(codes.DeadlineExceeded, "upload made no progress for %v: %v", uploadStallTimeout, err)
+	}
+
+	return res, err
+}
+
+// sendFrom streams file, read from offset on, to the upload session and calls progress after
+// every message that got through.
+func (imgClient *imgClient) sendFrom(ctx context.Context, file *os.File, uploadID string, offset int64, progress func()) (*pb.UploadResponse, error) {
 	stream, err := imgClient.service.UploadImage(ctx)
 	if err != nil {
-		log.Fatal("cannot upload image: ", err)
+		return nil, err
 	}
 
+	resume := &pb.ResumeUpload{UploadId: uploadID, Offset: offset}
+	err = stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_Resume{Resume: resume}})
+
 	reader := bufio.NewReader(file)
-	buffer := make([]byte, 1024)
+	buffer := make([]byte, 64<<10)
 
-	for {
-		n, err := reader.Read(buffer)
-		if err == io.EOF {
+	for err == nil {
+		n, readErr := reader.Read(buffer)
+		if readErr == io.EOF {
 			break
 		}
-		if err != nil {
-			log.Fatal("cannot read chunk to buffer: ", err)
+		if readErr != nil {
+			return nil, fmt.Errorf("cannot read chunk to buffer: %w", readErr)
 		}
 
 		req := &pb.UploadRequest{
-			ImageData: buffer[:n],
-			Filename:  filename,
+			Data: &pb.UploadRequest_ImageData{ImageData: buffer[:n]},
 		}
 		err = stream.Send(req)
-		if err != nil {
-			log.Fatal("cannot send chunk to server: ", err)
+		if err == nil {
+			progress()
 		}
 	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return stream.CloseAndRecv()
+}
+
+// retryable reports whether a failed call may succeed when repeated.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.ResourceExhausted, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// describeImage builds the upload header for file and rewinds it to the start.
+func describeImage(file *os.File, filename string, tags []string) (*pb.ImageHeader, error) {
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("cannot read file header: %w", err)
+	}
 
-	res, err := stream.CloseAndRecv()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
 	if err != nil {
-		log.Fatal("cannot receive response: ", err)
+		return nil, fmt.Errorf("cannot compute file checksum: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("cannot rewind file: %w", err)
 	}
 
-	log.Printf("image %s uploaded at: %s", filename, res.GetCreatedAt())
+	return &pb.ImageHeader{
+		Filename:    filename,
+		Size:        size,
+		ContentType: http.DetectContentType(head[:n]),
+		Sha256:      hex.EncodeToString(hash.Sum(nil)),
+		Tags:        tags,
+	}, nil
 }
 
 func (imgClient *imgClient) InformImage() (*pb.InformResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := imgClient.newContext(5 * time.Second)
 	defer cancel()
 
 	stream, err := imgClient.service.InformImage(ctx)
@@ -90,8 +256,97 @@ func (imgClient *imgClient) InformImage() (*pb.InformResponse, error) {
 	return resp, nil
 }
 
+// ListImages returns one page of image records.
+func (imgClient *imgClient) ListImages(req *pb.ListImagesRequest) (*pb.ListImagesResponse, error) {
+	ctx, cancel := imgClient.newContext(5 * time.Second)
+	defer cancel()
+
+	return imgClient.service.ListImages(ctx, req)
+}
+
+// ListAllImages follows the page tokens of ListImages and returns every matching record.
+func (imgClient *imgClient) ListAllImages(prefix string, orderBy pb.SortOrder) ([]*pb.ImageInfo, error) {
+	var images []*pb.ImageInfo
+	req := &pb.ListImagesRequest{FilenamePrefix: prefix, OrderBy: orderBy}
+	for {
+		res, err := imgClient.ListImages(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list images: %w", err)
+		}
+		images = append(images, res.GetImages()...)
+		if res.GetNextPageToken() == "" {
+			return images, nil
+		}
+		req.PageToken = res.GetNextPageToken()
+	}
+}
+
+// StatImage returns the record of filename without downloading it.
+func (imgClient *imgClient) StatImage(filename string) (*pb.ImageInfo, error) {
+	ctx, cancel := imgClient.newContext(5 * time.Second)
+	defer cancel()
+
+	req := &pb.StatImageRequest{Image: &pb.ImageRef{Ref: &pb.ImageRef_Filename{Filename: filename}}}
+	res, err := imgClient.service.StatImage(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat image: %w", err)
+	}
+
+	return res.GetImage(), nil
+}
+
+// DeleteImage removes filename from the server and returns the record it had.
+func (imgClient *imgClient) DeleteImage(filename string) (*pb.ImageInfo, error) {
+	ctx, cancel := imgClient.newContext(5 * time.Second)
+	defer cancel()
+
+	req := &pb.DeleteImageRequest{Image: &pb.ImageRef{Ref: &pb.ImageRef_Filename{Filename: filename}}}
+	res, err := imgClient.service.DeleteImage(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot delete image: %w", err)
+	}
+
+	return res.GetImage(), nil
+}
+
+// RenameImage moves filename to newFilename, resolving a taken name according to onConflict.
+func (imgClient *imgClient) RenameImage(filename, newFilename string, onConflict pb.ConflictPolicy) (*pb.ImageInfo, error) {
+	ctx, cancel := imgClient.newContext(5 * time.Second)
+	defer cancel()
+
+	req := &pb.RenameImageRequest{
+		Image:       &pb.ImageRef{Ref: &pb.ImageRef_Filename{Filename: filename}},
+		NewFilename: newFilename,
+		OnConflict:  onConflict,
+	}
+	res, err := imgClient.service.RenameImage(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot rename image: %w", err)
+	}
+
+	return res.GetImage(), nil
+}
+
+// CopyImage stores a copy of filename as newFilename, resolving a taken name according to onConflict.
+func (imgClient *imgClient) CopyImage(filename, newFilename string, onConflict pb.ConflictPolicy) (*pb.ImageInfo, error) {
+	ctx, cancel := imgClient.newContext(time.Minute)
+	defer cancel()
+
+	req := &pb.CopyImageRequest{
+		Image:       &pb.ImageRef{Ref: &pb.ImageRef_Filename{Filename: filename}},
+		NewFilename: newFilename,
+		OnConflict:  onConflict,
+	}
+	res, err := imgClient.service.CopyImage(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot copy image: %w", err)
+	}
+
+	return res.GetImage(), nil
+}
+
 func (imgClient *imgClient) DownloadImage(filename string) (*pb.DownloadResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := imgClient.newContext(5 * time.Second)
 	defer cancel()
 
 	stream, err := imgClient.service.DownloadImage(ctx)
@@ -112,3 +367,134 @@ func (imgClient *imgClient) DownloadImage(filename string) (*pb.DownloadResponse
 
 	return resp, nil
 }
+
+// StreamImage downloads filename chunk by chunk into w and verifies the size and checksum
+// announced by the server.
+func (imgClient *imgClient) StreamImage(filename string, w io.Writer) (*pb.ImageHeader, error) {
+	return imgClient.StreamImageRange(filename, 0, 0, w)
+}
+
+// StreamImageRange downloads length bytes of filename starting at offset into w; a zero
+// length reads up to the end. The checksum is verified whenever the range is the whole image.
+func (imgClient *imgClient) StreamImageRange(filename string, offset, length int64, w io.Writer) (*pb.ImageHeader, error) {
+	ctx, cancel := imgClient.newContext(time.Minute)
+	defer cancel()
+
+	req := &pb.DownloadRequest{Filename: filename, Offset: offset, Length: length}
+	stream, err := imgClient.service.StreamImage(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot call stream_image method: %w", err)
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("cannot receive image header: %w", err)
+	}
+	header := resp.GetHeader()
+	if header == nil {
+		return nil, fmt.Errorf("server did not send image header first")
+	}
+
+	hash := sha256.New()
+	out := io.MultiWriter(w, hash)
+	var received int64
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot receive chunk: %w", err)
+		}
+
+		n, err := out.Write(resp.GetImageData())
+		if err != nil {
+			return nil, fmt.Errorf("cannot write chunk: %w", err)
+		}
+		received += int64(n)
+	}
+
+	if received != header.GetLength() {
+		return nil, fmt.Errorf("received %d bytes, expected %d", received, header.GetLength())
+	}
+	if header.GetOffset() == 0 && header.GetLength() == header.GetSize() && header.GetSha256() != "" {
+		if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != header.GetSha256() {
+			return nil, fmt.Errorf("got %s, expected %s: %w", checksum, header.GetSha256(), errChecksumMismatch)
+		}
+	}
+
+	return header, nil
+}
+
+// partSuffix names the file a download is written to until it is complete and verified.
+const partSuffix = ".part"
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// ResumeDownload downloads filename to path. The data goes to path.part first, and a
+// download interrupted earlier continues after whatever that file already holds. The file
+// is moved to path once the checksum of the whole image matches, and removed if it does not.
+func (imgClient *imgClient) ResumeDownload(filename string, path string) (*pb.ImageHeader, error) {
+	partPath := path + partSuffix
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open partial file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat partial file: %w", err)
+	}
+
+	header, err := imgClient.StreamImageRange(filename, info.Size(), 0, file)
+	if status.Code(err) == codes.OutOfRange || errors.Is(err, errChecksumMismatch) {
+		// The image changed since the partial file was started, so it cannot be continued.
+		file.Close()
+		os.Remove(partPath)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if header.GetOffset() != 0 && header.GetSha256() != "" {
+		err = verifyFile(partPath, header.GetSha256())
+		if err != nil {
+			file.Close()
+			os.Remove(partPath)
+			return nil, err
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot close partial file: %w", err)
+	}
+	err = os.Rename(partPath, path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot move downloaded file into place: %w", err)
+	}
+
+	return header, nil
+}
+
+// verifyFile checks the whole file at path against checksum. Only the tail of a resumed
+// download went through StreamImageRange, so the stitched file is hashed again.
+func verifyFile(path, checksum string) error {
+	local, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot reopen partial file: %w", err)
+	}
+	defer local.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, local); err != nil {
+		return fmt.Errorf("cannot compute local checksum: %w", err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != checksum {
+		return fmt.Errorf("got %s, expected %s: %w", got, checksum, errChecksumMismatch)
+	}
+
+	return nil
+}
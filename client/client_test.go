@@ -0,0 +1,184 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	imageworkergrpc "github.com/Niiazgulov/tages.git/internal/grpc/imageworker"
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/Niiazgulov/tages.git/internal/storage/storagetest"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestClient returns a client of a server holding the image a.bin with data.
+func newTestClient(t *testing.T, data []byte) *imgClient {
+	t.Helper()
+
+	store, repo := storage.NewDiskImageStore(t.TempDir()), storagetest.Bolt(t)
+	_, err := store.SaveNewImage(bytes.NewReader(data), storage.ImagesInfo{Filename: "a.bin", CreatedAt: time.Now()}, repo)
+	if err != nil {
+		t.Fatalf("cannot save image: %v", err)
+	}
+
+	server := grpc.NewServer()
+	imageworkergrpc.Register(server, store, repo, nil)
+
+	return serve(t, server)
+}
+
+// serve runs server over an in-memory connection and returns a client of it.
+func serve(t *testing.T, server *grpc.Server) *imgClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("cannot dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return NewImgWorkerClient(conn)
+}
+
+func noError(err error) bool {
+	return err == nil
+}
+
+func TestResumeDownload(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 20000)
+	client := newTestClient(t, data)
+
+	tests := []struct {
+		name  string
+		part  []byte
+		valid func(error) bool
+	}{
+		{"fresh", nil, noError},
+		{"continued", data[:12345], noError},
+		{"corrupt part", append([]byte("garbage"), data[7:100]...), func(err error) bool { return errors.Is(err, errChecksumMismatch) }},
+		{"part longer than image", append(data, 'x'), func(err error) bool { return status.Code(err) == codes.OutOfRange }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.bin")
+			// A file already at path is not mistaken for an earlier part of the download.
+			if err := os.WriteFile(path, []byte("unrelated"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.part != nil {
+				if err := os.WriteFile(path+partSuffix, tt.part, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := client.ResumeDownload("a.bin", path)
+			if !tt.valid(err) {
+				t.Errorf("ResumeDownload ended with unexpected error %v", err)
+			}
+			if _, statErr := os.Stat(path + partSuffix); !os.IsNotExist(statErr) {
+				t.Errorf("partial file is left after the download ended with %v", err)
+			}
+
+			got, _ := os.ReadFile(path)
+			if err == nil && !bytes.Equal(got, data) {
+				t.Errorf("downloaded file holds %d bytes that differ from the image", len(got))
+			}
+			if err != nil && string(got) != "unrelated" {
+				t.Errorf("failed download replaced the file at path")
+			}
+		})
+	}
+}
+
+// pacedServer takes delay to receive every upload message. With stall set it stops reading
+// after the first one and never answers.
+type pacedServer struct {
+	pb.UnimplementedImageWorkerServer
+	delay time.Duration
+	stall bool
+}
+
+func (s *pacedServer) UploadImage(stream pb.ImageWorker_UploadImageServer) error {
+	var received int64
+	for first := true; ; first = false {
+		if s.stall && !first {
+			<-stream.Context().Done()
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+		time.Sleep(s.delay)
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		received += int64(len(req.GetImageData()))
+	}
+
+	return stream.SendAndClose(&pb.UploadResponse{ImageId: "uploaded", Received: received})
+}
+
+func TestUploadStallTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { uploadStallTimeout = timeout }(uploadStallTimeout)
+	uploadStallTimeout = 100 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(path, make([]byte, 8<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	upload := func(server *pacedServer) (*pb.UploadResponse, time.Duration, error) {
+		grpcServer := grpc.NewServer()
+		pb.RegisterImageWorkerServer(grpcServer, server)
+		start := time.Now()
+		res, err := serve(t, grpcServer).uploadFrom(file, "upload", 0)
+		return res, time.Since(start), err
+	}
+
+	// An upload that keeps going may take far longer than the timeout.
+	res, took, err := upload(&pacedServer{delay: 2 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("slow upload failed after %v: %v", took, err)
+	}
+	if res.GetReceived() != 8<<20 {
+		t.Errorf("server received %d bytes, want %d", res.GetReceived(), 8<<20)
+	}
+	if took < 2*uploadStallTimeout {
+		t.Errorf("slow upload took %v, too little to outlast the stall timeout", took)
+	}
+
+	// One that stops moving is given up as retryable.
+	_, took, err = upload(&pacedServer{stall: true})
+	if status.Code(err) != codes.DeadlineExceeded || !retryable(err) {
+		t.Errorf("stalled upload ended with %v, want DeadlineExceeded", err)
+	}
+	if took > 10*uploadStallTimeout {
+		t.Errorf("stalled upload was given up after %v", took)
+	}
+}
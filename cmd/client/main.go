@@ -6,11 +6,14 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 
 	"github.com/Niiazgulov/tages.git/client"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
@@ -23,11 +26,13 @@ func main() {
 		log.Fatal("cannot open client grpc dial connection")
 	}
 	client := client.NewImgWorkerClient(conn)
+	client.SetClientID(os.Getenv("TAGES_CLIENT_ID"))
+	client.SetClientKey(os.Getenv("TAGES_CLIENT_KEY"))
 
 	files := filesInFolder()
 
 	var actionchoice string
-	fmt.Println("Введите цифру нужного действия:\n 1. Отправить новое изображение на жесткий диск \n 2. Просмотр списка всех загруженных файлов на жестком диске \n 3. Загрузить изображение с сервера")
+	fmt.Println("Введите цифру нужного действия:\n 1. Отправить новое изображение на жесткий диск \n 2. Просмотр списка всех загруженных файлов на жестком диске \n 3. Загрузить изображение с сервера \n 4. Удалить изображение с сервера")
 	fmt.Fscan(os.Stdin, &actionchoice)
 
 	switch actionchoice {
@@ -54,52 +59,66 @@ func main() {
 		client.UploadImage(strings.Join([]string{imagePath, selectedFile}, ""), selectedFile)
 
 	case "2":
-		res, err := client.InformImage()
+		images, err := client.ListAllImages("", pb.SortOrder_SORT_BY_NAME)
 		if err != nil {
-			log.Fatal("cannot get all images info into client main")
+			log.Fatal("cannot get all images info into client main: ", err)
 		}
-		sliceofslice := res.GetResponse()
 		fmt.Printf("|%15s|%35s|%35s|\n", "Имя файла", "Дата создания", "Дата обновления")
-		for _, v := range sliceofslice {
-			slice := v.GetValue()
-			fmt.Printf("|%15s|%35s|%35s|\n", slice[0], slice[1], slice[2])
+		for _, img := range images {
+			fmt.Printf("|%15s|%35s|%35s|\n", img.GetFilename(), formatTime(img.GetCreatedAt()), formatTime(img.GetChangedAt()))
 		}
 
 	case "3":
 		fmt.Println("Введите номер файла, который вы хотите получить:")
-		fmt.Printf("|%15s|%15s|%35s|%35s|\n", "Номер файла", "Имя файла", "Дата создания", "Дата обновления")
+		filechoice := chooseImage(client)
 
-		res, err := client.InformImage()
+		_, err = client.ResumeDownload(filechoice, filechoice)
 		if err != nil {
-			log.Fatal("cannot get all images info into client main")
+			log.Fatal("cannot get image into client main: ", err)
 		}
-		sliceofslice := res.GetResponse()
 
-		for i, v := range sliceofslice {
-			slice := v.GetValue()
-			fmt.Printf("|%15d|%15s|%35s|%35s|\n", i+1, slice[0], slice[1], slice[2])
-		}
-
-		var filechoiceInt int
-		fmt.Fscan(os.Stdin, &filechoiceInt)
-		filechoice := files[filechoiceInt-1]
+		log.Printf("Файл %s успешно сохранен!", filechoice)
 
-		res2, err := client.DownloadImage(filechoice)
-		if err != nil {
-			log.Fatal("cannot get image into client main")
-		}
+	case "4":
+		fmt.Println("Введите номер файла, который вы хотите удалить:")
+		filechoice := chooseImage(client)
 
-		imgByte := res2.ImageData
-		err = os.WriteFile(filechoice, imgByte, 0644)
+		_, err = client.DeleteImage(filechoice)
 		if err != nil {
-			log.Fatal("cannot save byte array into file (client main)")
+			log.Fatal("cannot delete image from client main: ", err)
 		}
 
-		log.Printf("Файл %s успешно сохранен!", filechoice)
+		log.Printf("Файл %s успешно удален!", filechoice)
 
 	default:
-		fmt.Println("Попробуйте еще раз. Нужно ввести цифру от 1 до 3")
+		fmt.Println("Попробуйте еще раз. Нужно ввести цифру от 1 до 4")
+	}
+}
+
+type imageLister interface {
+	ListAllImages(prefix string, orderBy pb.SortOrder) ([]*pb.ImageInfo, error)
+}
+
+// chooseImage prints the images stored on the server and returns the filename the user picks.
+func chooseImage(client imageLister) string {
+	fmt.Printf("|%15s|%15s|%35s|%35s|\n", "Номер файла", "Имя файла", "Дата создания", "Дата обновления")
+
+	images, err := client.ListAllImages("", pb.SortOrder_SORT_BY_NAME)
+	if err != nil {
+		log.Fatal("cannot get all images info into client main: ", err)
+	}
+
+	for i, img := range images {
+		fmt.Printf("|%15d|%15s|%35s|%35s|\n", i+1, img.GetFilename(), formatTime(img.GetCreatedAt()), formatTime(img.GetChangedAt()))
 	}
+
+	var filechoiceInt int
+	fmt.Fscan(os.Stdin, &filechoiceInt)
+	if filechoiceInt < 1 || filechoiceInt > len(images) {
+		log.Fatalf("Нужно ввести число от 1 до %d", len(images))
+	}
+
+	return images[filechoiceInt-1].GetFilename()
 }
 
 func filesInFolder() []string {
@@ -120,3 +139,8 @@ func filesInFolder() []string {
 	}
 	return filenames
 }
+
+// formatTime shows a timestamp of the server in local time.
+func formatTime(ts *timestamppb.Timestamp) string {
+	return ts.AsTime().Local().Format(time.RFC850)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+)
+
+// runFsck implements "tages [-config path] fsck [flags]": it checks the image folder against
+// the DB, applies the requested repairs and writes a JSON report. The exit code is 1 when
+// the check or a repair failed and 2 when inconsistencies were found.
+func runFsck(imageStore *storage.ImageStore, repo storage.ImageDB, args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	var opts storage.FsckOptions
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "report what would be repaired without changing anything")
+	flags.BoolVar(&opts.RecreateRows, "recreate-rows", false, "create records, named after their checksum, for files that have none")
+	flags.BoolVar(&opts.QuarantineFiles, "quarantine", false, "move files that have no record to the quarantine folder")
+	flags.BoolVar(&opts.DeleteRows, "delete-rows", false, "delete records whose file is missing")
+	output := flags.String("o", "", "write the JSON report to this file instead of stdout")
+	flags.Parse(args)
+
+	// Records must point at content before fsck can tell which of them lost their image, but a
+	// dry run changes nothing, so images stored by earlier versions are reported as they are.
+	if !opts.DryRun {
+		err := migrateStorage(imageStore, repo)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fsck: cannot migrate stored images:", err)
+			return 1
+		}
+	}
+
+	report, err := imageStore.Fsck(repo, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsck:", err)
+		return 1
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "fsck:", err)
+			return 1
+		}
+		defer out.Close()
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsck: cannot write report:", err)
+		return 1
+	}
+
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	if !report.Consistent() {
+		return 2
+	}
+	return 0
+}
@@ -1,29 +1,143 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Niiazgulov/tages.git/internal/app"
 	"github.com/Niiazgulov/tages.git/internal/config"
 	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/Niiazgulov/tages.git/internal/storage/blob"
 )
 
 func main() {
 	cfg := config.MustLoad()
-	imageStore := storage.NewDiskImageStore(cfg.StoragePath)
-	repo, err := storage.NewDB(cfg.DBPath)
+	imageStore, err := openImageStore(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	appl := app.New(cfg.GRPC.Port, cfg.StoragePath, imageStore, repo)
+	imageStore.SetQuotas(quotas(cfg.Quotas))
+	repo, err := openDB(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "migrate":
+		// Lets a large image folder be converted before the server is started.
+		err = migrateStorage(imageStore, repo)
+		repo.Close()
+		if err != nil {
+			log.Fatalf("cannot migrate stored images: %v", err)
+		}
+		return
+	case "fsck":
+		code := runFsck(imageStore, repo, flag.Args()[1:])
+		repo.Close()
+		os.Exit(code)
+	}
+
+	err = migrateStorage(imageStore, repo)
+	if err != nil {
+		log.Fatalf("cannot migrate stored images: %v", err)
+	}
+
+	// Nothing is being written before the server starts, so every temp file is left over from a crash.
+	if n, err := imageStore.RemoveStaleTemps(time.Now()); err != nil {
+		log.Printf("cannot remove stale temp files: %v", err)
+	} else if n > 0 {
+		log.Printf("removed %d stale temp files", n)
+	}
+	appl := app.New(cfg, imageStore, repo)
 	go appl.GRPCServ.Run()
+	appl.StartJobs()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
 	<-stop
-	appl.GRPCServ.Stop()
+	appl.Stop()
+}
+
+// migrateStorage brings images stored by earlier versions into the current layout: images
+// kept under their filename and content not yet spread over folders.
+func migrateStorage(imageStore *storage.ImageStore, repo storage.ImageDB) error {
+	moved, err := imageStore.MigrateContentLayout()
+	if moved > 0 {
+		log.Printf("moved %d content blobs into the sharded layout", moved)
+	}
+	if err != nil {
+		return err
+	}
+
+	imported, skipped, err := imageStore.ImportLegacyImages(repo)
+	if imported > 0 || skipped > 0 {
+		log.Printf("imported %d images stored by filename, left %d without records in place", imported, skipped)
+	}
+
+	return err
+}
+
+// quotas converts the quota settings of the config into the limits of the image store.
+func quotas(cfg config.QuotasConfig) storage.Quotas {
+	quota := func(q config.QuotaConfig) storage.Quota {
+		return storage.Quota{MaxBytes: q.MaxBytes, MaxFiles: q.MaxFiles, SoftBytes: q.SoftBytes, SoftFiles: q.SoftFiles}
+	}
+
+	namespaces := make(map[string]storage.Quota, len(cfg.Namespaces))
+	for name, q := range cfg.Namespaces {
+		namespaces[name] = quota(q)
+	}
+
+	return storage.Quotas{
+		Global:     quota(cfg.Global),
+		Namespace:  quota(cfg.Namespace),
+		Namespaces: namespaces,
+	}
+}
+
+// openDB opens the records DB chosen in the config.
+func openDB(cfg *config.Config) (storage.ImageDB, error) {
+	switch cfg.DBBackend {
+	case "", "postgres":
+		return storage.NewDB(cfg.DBPath)
+	case "bolt":
+		return storage.NewBoltDB(cfg.DBPath)
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", cfg.DBBackend)
+	}
+}
+
+// openImageStore builds the image store on the blob backend chosen in the config.
+func openImageStore(cfg *config.Config) (*storage.ImageStore, error) {
+	staging := cfg.StoragePath + "/.partial"
+
+	switch cfg.Storage.Backend {
+	case "", "disk":
+		return storage.NewDiskImageStore(cfg.StoragePath), nil
+	case "memory":
+		return storage.NewImageStore(blob.NewMemory(), staging), nil
+	case "s3":
+		s3 := cfg.Storage.S3
+		blobs, err := blob.NewS3(blob.S3Config{
+			Endpoint:  s3.Endpoint,
+			Region:    s3.Region,
+			Bucket:    s3.Bucket,
+			Prefix:    s3.Prefix,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			UseSSL:    s3.UseSSL,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewImageStore(blobs, staging), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
 }
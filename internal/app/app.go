@@ -1,15 +1,159 @@
 package app
 
 import (
+	"log"
+	"sync"
+	"time"
+
 	grpcapp "github.com/Niiazgulov/tages.git/internal/app/grpc"
+	"github.com/Niiazgulov/tages.git/internal/config"
 	"github.com/Niiazgulov/tages.git/internal/storage"
 )
 
 type App struct {
 	GRPCServ *grpcapp.App
+	jobs     []job
+	done     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// job is a maintenance task run periodically while the server is up.
+type job struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+func New(cfg *config.Config, imgProcessor storage.ImageProcessor, repo storage.ImageDB) *App {
+	grpcApp := grpcapp.New(cfg.GRPC.Port, cfg.GRPC.Limits, cfg.GRPC.ClientKeys, imgProcessor, repo)
+
+	jobs := []job{
+		{
+			name:     "expire upload sessions",
+			interval: cfg.Uploads.CleanupInterval,
+			run: func() error {
+				n, err := storage.ExpireUploadSessions(imgProcessor, repo, time.Now().Add(-cfg.Uploads.SessionTTL))
+				if n > 0 {
+					log.Printf("expired %d abandoned upload sessions", n)
+				}
+				return err
+			},
+		},
+		{
+			name:     "check storage consistency",
+			interval: cfg.Fsck.Interval,
+			run: func() error {
+				report, err := imgProcessor.Fsck(repo, storage.FsckOptions{
+					DryRun:          cfg.Fsck.DryRun,
+					RecreateRows:    cfg.Fsck.RecreateRows,
+					QuarantineFiles: cfg.Fsck.QuarantineFiles,
+					DeleteRows:      cfg.Fsck.DeleteRows,
+				})
+				if err != nil {
+					return err
+				}
+				if !report.Consistent() {
+					log.Printf("storage check found %d files without records and %d records without files",
+						len(report.FilesWithoutRows), len(report.RowsWithoutFiles))
+				}
+				for _, failure := range report.Errors {
+					log.Printf("storage check repair failed: %s", failure)
+				}
+				return nil
+			},
+		},
+		{
+			name:     "collect unreferenced content",
+			interval: cfg.GC.Interval,
+			run: func() error {
+				n, err := imgProcessor.CollectGarbage(repo, time.Now().Add(-cfg.GC.Grace))
+				if n > 0 {
+					log.Printf("removed %d unreferenced content blobs", n)
+				}
+				return err
+			},
+		},
+		{
+			name:     "prune image versions",
+			interval: cfg.Versions.PruneInterval,
+			run: func() error {
+				var before time.Time
+				if cfg.Versions.KeepFor > 0 {
+					before = time.Now().Add(-cfg.Versions.KeepFor)
+				}
+				n, err := repo.DeleteOldVersions(cfg.Versions.KeepLast, before)
+				if n > 0 {
+					log.Printf("removed %d old image versions", n)
+				}
+				return err
+			},
+		},
+		{
+			name:     "purge trash",
+			interval: cfg.Trash.PurgeInterval,
+			run: func() error {
+				if cfg.Trash.Retention <= 0 {
+					return nil
+				}
+				n, err := repo.PurgeTrash("", time.Now().Add(-cfg.Trash.Retention))
+				if n > 0 {
+					log.Printf("purged %d images from the trash", n)
+				}
+				return err
+			},
+		},
+		{
+			name:     "remove stale temp files",
+			interval: cfg.Uploads.CleanupInterval,
+			run: func() error {
+				n, err := imgProcessor.RemoveStaleTemps(time.Now().Add(-cfg.Uploads.SessionTTL))
+				if n > 0 {
+					log.Printf("removed %d stale temp files", n)
+				}
+				return err
+			},
+		},
+	}
+
+	return &App{GRPCServ: grpcApp, jobs: jobs, done: make(chan struct{})}
+}
+
+// StartJobs launches the maintenance jobs that have a positive interval.
+func (a *App) StartJobs() {
+	for _, j := range a.jobs {
+		if j.interval <= 0 {
+			continue
+		}
+		a.wg.Add(1)
+		go a.runJob(j)
+	}
+}
+
+func (a *App) runJob(j job) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			if err := j.run(); err != nil {
+				log.Printf("job %q failed: %v", j.name, err)
+			}
+		}
+	}
 }
 
-func New(grpcPort int, storagePath string, imgProcessor storage.ImageProcessor, repo storage.ImageDB) *App {
-	grpcApp := grpcapp.New(grpcPort, imgProcessor, repo)
-	return &App{GRPCServ: grpcApp}
+// Stop stops the maintenance jobs and gracefully stops the gRPC server. Calls after the
+// first do nothing.
+func (a *App) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.done)
+		a.wg.Wait()
+		a.GRPCServ.Stop()
+	})
 }
@@ -2,25 +2,59 @@ package grpcapp
 
 import (
 	"fmt"
+	"log"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/Niiazgulov/tages.git/internal/config"
 	imageworkergrpc "github.com/Niiazgulov/tages.git/internal/grpc/imageworker"
+	"github.com/Niiazgulov/tages.git/internal/grpc/limiter"
 	"github.com/Niiazgulov/tages.git/internal/storage"
 	"google.golang.org/grpc"
 )
 
 type App struct {
-	gRPCServer   *grpc.Server
-	port         int
-	imgProcessor storage.ImageProcessor
-	repo         storage.ImageDB
+	gRPCServer    *grpc.Server
+	port          int
+	imgProcessor  storage.ImageProcessor
+	repo          storage.ImageDB
+	limiter       *limiter.Limiter
+	statsInterval time.Duration
+	done          chan struct{}
+	stopOnce      sync.Once
 }
 
-func New(port int, imgProcessor storage.ImageProcessor, repo storage.ImageDB) *App {
-	gRPCServer := grpc.NewServer()
-	imageworkergrpc.Register(gRPCServer, imgProcessor, repo)
+func New(port int, limits config.LimitsConfig, clientKeys map[string]string, imgProcessor storage.ImageProcessor, repo storage.ImageDB) *App {
+	lim := limiter.New(imageworkergrpc.MethodPool, limiter.Config{
+		Global: map[limiter.Pool]int{
+			limiter.Transfer: limits.Transfer,
+			limiter.Listing:  limits.Listing,
+		},
+		PerClient: map[limiter.Pool]int{
+			limiter.Transfer: limits.PerClient.Transfer,
+			limiter.Listing:  limits.PerClient.Listing,
+		},
+		ClientKeys:  clientKeys,
+		IdleTimeout: limits.ClientIdleTimeout,
+		Admission:   limiter.Admission(limits.Admission),
+		MaxWait:     limits.MaxWait,
+	})
+	gRPCServer := grpc.NewServer(
+		grpc.UnaryInterceptor(lim.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(lim.StreamServerInterceptor()),
+	)
+	imageworkergrpc.Register(gRPCServer, imgProcessor, repo, clientKeys)
 
-	return &App{gRPCServer: gRPCServer, port: port, imgProcessor: imgProcessor, repo: repo}
+	return &App{
+		gRPCServer:    gRPCServer,
+		port:          port,
+		imgProcessor:  imgProcessor,
+		repo:          repo,
+		limiter:       lim,
+		statsInterval: limits.StatsInterval,
+		done:          make(chan struct{}),
+	}
 }
 
 func (a *App) Run() error {
@@ -28,6 +62,9 @@ func (a *App) Run() error {
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
+	if a.statsInterval > 0 {
+		go a.logLimiterStats()
+	}
 	if err := a.gRPCServer.Serve(listener); err != nil {
 		return fmt.Errorf("%w", err)
 	}
@@ -35,6 +72,31 @@ func (a *App) Run() error {
 	return nil
 }
 
+// LimiterStats exposes the occupancy and queue depth of the concurrency pools.
+func (a *App) LimiterStats() map[limiter.Pool]limiter.PoolStats {
+	return a.limiter.Stats()
+}
+
+func (a *App) logLimiterStats() {
+	ticker := time.NewTicker(a.statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			for pool, stats := range a.limiter.Stats() {
+				log.Printf("limiter pool %s: in flight %d/%d, queued %d", pool, stats.InFlight, stats.Limit, stats.Queued)
+			}
+		}
+	}
+}
+
+// Stop gracefully stops the server. Calls after the first do nothing.
 func (a *App) Stop() {
-	a.gRPCServer.GracefulStop()
+	a.stopOnce.Do(func() {
+		close(a.done)
+		a.gRPCServer.GracefulStop()
+	})
 }
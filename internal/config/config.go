@@ -2,22 +2,126 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
+// Config is the server configuration. DBBackend keeps image records in "postgres", with DBPath
+// its connection string, or in "bolt", an embedded store in the file at DBPath that needs no
+// server.
 type Config struct {
-	Env         string     `yaml:"env" env-default:"local"`
-	StoragePath string     `yaml:"storage_path" env-required:"true"`
-	DBPath      string     `yaml:"database_path"`
-	GRPC        GRPCConfig `yaml:"grpc"`
+	Env         string         `yaml:"env" env-default:"local"`
+	StoragePath string         `yaml:"storage_path" env-required:"true"`
+	Storage     StorageConfig  `yaml:"storage"`
+	DBBackend   string         `yaml:"database_backend" env-default:"postgres"`
+	DBPath      string         `yaml:"database_path"`
+	GRPC        GRPCConfig     `yaml:"grpc"`
+	Uploads     UploadsConfig  `yaml:"uploads"`
+	Fsck        FsckConfig     `yaml:"fsck"`
+	GC          GCConfig       `yaml:"gc"`
+	Versions    VersionsConfig `yaml:"versions"`
+	Trash       TrashConfig    `yaml:"trash"`
+	Quotas      QuotasConfig   `yaml:"quotas"`
 }
 
+// StorageConfig selects where image content is kept: "disk" (in StoragePath), "memory"
+// or "s3". Unfinished uploads are staged under StoragePath whatever the backend.
+type StorageConfig struct {
+	Backend string   `yaml:"backend" env-default:"disk"`
+	S3      S3Config `yaml:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key" env:"S3_ACCESS_KEY"`
+	SecretKey string `yaml:"secret_key" env:"S3_SECRET_KEY"`
+	UseSSL    bool   `yaml:"use_ssl"`
+}
+
+// GRPCConfig sets up the server. ClientKeys maps client ids to the keys proving them: a client
+// is put into the namespace and the per-client limits of its x-client-id only if it sends the
+// matching x-client-key, and is otherwise told apart by its address.
 type GRPCConfig struct {
-	Port    int           `yaml:"port"`
-	Timeout time.Duration `yaml:"timeout"`
+	Port       int               `yaml:"port"`
+	Timeout    time.Duration     `yaml:"timeout"`
+	Limits     LimitsConfig      `yaml:"limits"`
+	ClientKeys map[string]string `yaml:"client_keys"`
+}
+
+type LimitsConfig struct {
+	Transfer          int             `yaml:"transfer" env-default:"10"`
+	Listing           int             `yaml:"listing" env-default:"100"`
+	PerClient         PerClientLimits `yaml:"per_client"`
+	ClientIdleTimeout time.Duration   `yaml:"client_idle_timeout" env-default:"5m"`
+	Admission         string          `yaml:"admission" env-default:"fail_fast"`
+	MaxWait           time.Duration   `yaml:"max_wait" env-default:"200ms"`
+	StatsInterval     time.Duration   `yaml:"stats_interval"`
+}
+
+type PerClientLimits struct {
+	Transfer int `yaml:"transfer"`
+	Listing  int `yaml:"listing"`
+}
+
+type UploadsConfig struct {
+	SessionTTL      time.Duration `yaml:"session_ttl" env-default:"24h"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval" env-default:"1h"`
+}
+
+// FsckConfig sets up the periodic check of the image folder against the DB. A zero
+// interval disables it; without any repair enabled the check only reports.
+type FsckConfig struct {
+	Interval        time.Duration `yaml:"interval"`
+	DryRun          bool          `yaml:"dry_run"`
+	RecreateRows    bool          `yaml:"recreate_rows"`
+	QuarantineFiles bool          `yaml:"quarantine_files"`
+	DeleteRows      bool          `yaml:"delete_rows"`
+}
+
+// GCConfig sets up the removal of image content no record refers to any more. Content is kept
+// for Grace after it was stored, so uploads of the same data can still reuse it.
+type GCConfig struct {
+	Interval time.Duration `yaml:"interval" env-default:"1h"`
+	Grace    time.Duration `yaml:"grace" env-default:"24h"`
+}
+
+// VersionsConfig sets how long the replaced versions of images are kept. A version is removed
+// once KeepLast newer versions of the image are kept or KeepFor has passed since it was
+// replaced; zero disables a rule.
+type VersionsConfig struct {
+	KeepLast      int           `yaml:"keep_last" env-default:"10"`
+	KeepFor       time.Duration `yaml:"keep_for" env-default:"720h"`
+	PruneInterval time.Duration `yaml:"prune_interval" env-default:"1h"`
+}
+
+// TrashConfig sets how long deleted images stay in the trash before they are purged for good.
+// A zero Retention keeps them until the trash is emptied.
+type TrashConfig struct {
+	Retention     time.Duration `yaml:"retention" env-default:"720h"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env-default:"1h"`
+}
+
+// QuotasConfig limits what is stored in total and per namespace, the authenticated client id
+// of the uploader (see GRPCConfig). Namespace applies to the namespaces not listed in Namespaces.
+type QuotasConfig struct {
+	Global     QuotaConfig            `yaml:"global"`
+	Namespace  QuotaConfig            `yaml:"namespace"`
+	Namespaces map[string]QuotaConfig `yaml:"namespaces"`
+}
+
+// QuotaConfig sets the hard limits uploads are refused at and the soft limits that only
+// lead to warnings in the log. Zero limits are off.
+type QuotaConfig struct {
+	MaxBytes  int64 `yaml:"max_bytes"`
+	MaxFiles  int64 `yaml:"max_files"`
+	SoftBytes int64 `yaml:"soft_bytes"`
+	SoftFiles int64 `yaml:"soft_files"`
 }
 
 func MustLoad() *Config {
@@ -36,9 +140,24 @@ func MustLoad() *Config {
 		panic("config path is empty2: " + err.Error())
 	}
 
+	if err := cfg.validate(); err != nil {
+		panic("invalid config: " + err.Error())
+	}
+
 	return &cfg
 }
 
+// validate rejects settings that would otherwise be silently treated as a default.
+func (cfg *Config) validate() error {
+	switch cfg.GRPC.Limits.Admission {
+	case "fail_fast", "wait":
+	default:
+		return fmt.Errorf("grpc.limits.admission is %q, want fail_fast or wait", cfg.GRPC.Limits.Admission)
+	}
+
+	return nil
+}
+
 func fetchConfigPath() string {
 	var res string
 
@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestValidateAdmission(t *testing.T) {
+	tests := []struct {
+		admission string
+		valid     bool
+	}{
+		{"fail_fast", true},
+		{"wait", true},
+		{"wiat", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		var cfg Config
+		cfg.GRPC.Limits.Admission = tt.admission
+		if err := cfg.validate(); (err == nil) != tt.valid {
+			t.Errorf("validate with admission %q = %v, want valid %v", tt.admission, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package imageworker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the google.rpc.ErrorInfo domain of the errors this service returns.
+const errorDomain = "tages.imageworker"
+
+// storageErrors maps the storage sentinels to the status returned for them. Reason goes
+// into ErrorInfo; errors caused by a request field also name it in a BadRequest violation.
+var storageErrors = []struct {
+	err    error
+	code   codes.Code
+	reason string
+	field  string
+}{
+	{storage.ErrImgNotFound, codes.NotFound, "IMAGE_NOT_FOUND", ""},
+	{storage.ErrUploadNotFound, codes.NotFound, "UPLOAD_NOT_FOUND", ""},
+	{storage.ErrVersionNotFound, codes.NotFound, "VERSION_NOT_FOUND", "version"},
+	{storage.ErrImgExists, codes.AlreadyExists, "IMAGE_EXISTS", ""},
+	{storage.ErrImgTooLarge, codes.InvalidArgument, "IMAGE_TOO_LARGE", "size"},
+	{storage.ErrInvalidName, codes.InvalidArgument, "INVALID_NAME", "filename"},
+	{storage.ErrRange, codes.OutOfRange, "RANGE_NOT_SATISFIABLE", "offset"},
+	{storage.ErrUploadOffset, codes.OutOfRange, "UPLOAD_OFFSET_MISMATCH", "offset"},
+	{storage.ErrQuotaExceeded, codes.ResourceExhausted, "QUOTA_EXCEEDED", ""},
+	{storage.ErrOtherNamespace, codes.PermissionDenied, "OTHER_NAMESPACE", "filename"},
+	{storage.ErrUnavailable, codes.Unavailable, "BACKEND_UNAVAILABLE", ""},
+	{context.Canceled, codes.Canceled, "CANCELED", ""},
+	{context.DeadlineExceeded, codes.DeadlineExceeded, "DEADLINE_EXCEEDED", ""},
+}
+
+// toStatus turns err into a gRPC status error whose message starts with msg. Errors that
+// already carry a status, such as failures of the stream itself, keep their code and
+// details; anything not known to storageErrors is Internal.
+func toStatus(msg string, err error) error {
+	if st, ok := status.FromError(err); ok {
+		proto := st.Proto()
+		proto.Message = msg + ": " + proto.Message
+		return status.ErrorProto(proto)
+	}
+
+	for _, known := range storageErrors {
+		if !errors.Is(err, known.err) {
+			continue
+		}
+
+		st := status.Newf(known.code, "%s: %v", msg, err)
+		if withInfo, detailsErr := st.WithDetails(&errdetails.ErrorInfo{Reason: known.reason, Domain: errorDomain}); detailsErr == nil {
+			st = withInfo
+		}
+		if known.field != "" {
+			violation := &errdetails.BadRequest_FieldViolation{Field: known.field, Description: err.Error()}
+			if withViolation, detailsErr := st.WithDetails(&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{violation}}); detailsErr == nil {
+				st = withViolation
+			}
+		}
+
+		return st.Err()
+	}
+
+	return status.Errorf(codes.Internal, "%s: %v", msg, err)
+}
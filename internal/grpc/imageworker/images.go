@@ -0,0 +1,166 @@
+package imageworker
+
+import (
+	"context"
+	"log"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// imageKey turns an ImageRef into the record key the storage layer expects.
+func imageKey(ref *pb.ImageRef) (storage.ImagesInfo, error) {
+	switch {
+	case ref.GetImageId() != "":
+		imageID, err := uuid.Parse(ref.GetImageId())
+		if err != nil {
+			return storage.ImagesInfo{}, status.Errorf(codes.InvalidArgument, "image_id %q is not a UUID", ref.GetImageId())
+		}
+		return storage.ImagesInfo{ImageId: imageID.String()}, nil
+	case ref.GetFilename() != "":
+		filename, err := storage.CleanFilename(ref.GetFilename())
+		if err != nil {
+			return storage.ImagesInfo{}, toStatus("invalid image reference", err)
+		}
+		return storage.ImagesInfo{Filename: filename}, nil
+	default:
+		return storage.ImagesInfo{}, status.Error(codes.InvalidArgument, "filename or image_id is required")
+	}
+}
+
+func (s *serverAPI) StatImage(ctx context.Context, req *pb.StatImageRequest) (*pb.StatImageResponse, error) {
+	key, err := imageKey(req.GetImage())
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	record, err := s.repo.GetInfo(key)
+	if err != nil {
+		return nil, logError(toStatus("cannot stat image", err))
+	}
+
+	return &pb.StatImageResponse{Image: imageInfo(record)}, nil
+}
+
+func (s *serverAPI) DeleteImage(ctx context.Context, req *pb.DeleteImageRequest) (*pb.DeleteImageResponse, error) {
+	key, err := imageKey(req.GetImage())
+	if err != nil {
+		return nil, logError(err)
+	}
+	key.Namespace = s.namespace(ctx)
+
+	deleted, err := s.imgProcessor.DeleteImage(key, s.repo)
+	if err != nil {
+		return nil, logError(toStatus("cannot delete image", err))
+	}
+
+	log.Printf("moved image %s to the trash", deleted.Filename)
+
+	return &pb.DeleteImageResponse{Image: imageInfo(deleted)}, nil
+}
+
+func conflictPolicy(policy pb.ConflictPolicy) storage.ConflictPolicy {
+	switch policy {
+	case pb.ConflictPolicy_CONFLICT_OVERWRITE:
+		return storage.ConflictOverwrite
+	case pb.ConflictPolicy_CONFLICT_AUTO_SUFFIX:
+		return storage.ConflictAutoSuffix
+	default:
+		return storage.ConflictFail
+	}
+}
+
+func (s *serverAPI) RenameImage(ctx context.Context, req *pb.RenameImageRequest) (*pb.RenameImageResponse, error) {
+	key, err := imageKey(req.GetImage())
+	if err != nil {
+		return nil, logError(err)
+	}
+	key.Namespace = s.namespace(ctx)
+
+	renamed, err := s.imgProcessor.RenameImage(key, req.GetNewFilename(), conflictPolicy(req.GetOnConflict()), s.repo)
+	if err != nil {
+		return nil, logError(toStatus("cannot rename image", err))
+	}
+
+	log.Printf("renamed image %s to %s", renamed.ImageId, renamed.Filename)
+
+	return &pb.RenameImageResponse{Image: imageInfo(renamed)}, nil
+}
+
+func (s *serverAPI) CopyImage(ctx context.Context, req *pb.CopyImageRequest) (*pb.CopyImageResponse, error) {
+	key, err := imageKey(req.GetImage())
+	if err != nil {
+		return nil, logError(err)
+	}
+	key.Namespace = s.namespace(ctx)
+
+	copied, err := s.imgProcessor.CopyImage(key, req.GetNewFilename(), conflictPolicy(req.GetOnConflict()), s.repo)
+	if err != nil {
+		return nil, logError(toStatus("cannot copy image", err))
+	}
+
+	log.Printf("copied image to %s", copied.Filename)
+	s.warnUsage(copied.Namespace)
+
+	return &pb.CopyImageResponse{Image: imageInfo(copied)}, nil
+}
+
+func (s *serverAPI) ListVersions(ctx context.Context, req *pb.ListVersionsRequest) (*pb.ListVersionsResponse, error) {
+	key, err := imageKey(req.GetImage())
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	record, err := s.repo.GetInfo(key)
+	if err != nil {
+		return nil, logError(toStatus("cannot stat image", err))
+	}
+	kept, err := s.repo.ListVersions(record.ImageId)
+	if err != nil {
+		return nil, logError(toStatus("cannot list image versions", err))
+	}
+
+	res := &pb.ListVersionsResponse{ImageId: record.ImageId, Filename: record.Filename}
+	res.Versions = append(res.Versions, &pb.ImageVersion{
+		Version:     int32(record.Version),
+		Size:        record.Size,
+		ContentType: record.ContentType,
+		Sha256:      record.Checksum,
+		CreatedAt:   timestamp(record.ChangedAt),
+		Current:     true,
+	})
+	for _, version := range kept {
+		res.Versions = append(res.Versions, &pb.ImageVersion{
+			Version:     int32(version.Version),
+			Size:        version.Size,
+			ContentType: version.ContentType,
+			Sha256:      version.Checksum,
+			CreatedAt:   timestamp(version.CreatedAt),
+		})
+	}
+
+	return res, nil
+}
+
+func (s *serverAPI) RestoreVersion(ctx context.Context, req *pb.RestoreVersionRequest) (*pb.RestoreVersionResponse, error) {
+	key, err := imageKey(req.GetImage())
+	if err != nil {
+		return nil, logError(err)
+	}
+	if req.GetVersion() <= 0 {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "version must be positive: %d", req.GetVersion()))
+	}
+	key.Namespace = s.namespace(ctx)
+
+	restored, err := s.imgProcessor.RestoreVersion(key, int(req.GetVersion()), s.repo)
+	if err != nil {
+		return nil, logError(toStatus("cannot restore image version", err))
+	}
+
+	log.Printf("restored version %d of image %s as version %d", req.GetVersion(), restored.Filename, restored.Version)
+
+	return &pb.RestoreVersionResponse{Image: imageInfo(restored)}, nil
+}
@@ -0,0 +1,134 @@
+package imageworker
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+func (s *serverAPI) ListImages(ctx context.Context, req *pb.ListImagesRequest) (*pb.ListImagesResponse, error) {
+	pageSize, offset, err := page(req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	opts := storage.ListOptions{
+		Prefix:     req.GetFilenamePrefix(),
+		OrderBy:    sortOrder(req.GetOrderBy()),
+		Descending: req.GetDescending(),
+		Offset:     offset,
+		// One extra record tells whether there is a next page.
+		Limit: pageSize + 1,
+	}
+	records, err := s.repo.ListInfo(opts)
+	if err != nil {
+		return nil, logError(toStatus("cannot list images", err))
+	}
+
+	res := &pb.ListImagesResponse{}
+	if len(records) > pageSize {
+		records = records[:pageSize]
+		res.NextPageToken = encodePageToken(offset + pageSize)
+	}
+	for _, record := range records {
+		res.Images = append(res.Images, imageInfo(record))
+	}
+
+	log.Printf("listed %d images", len(res.Images))
+
+	return res, nil
+}
+
+// page validates the paging fields of a listing request and returns the page size and the
+// offset of the page.
+func page(size int32, token string) (int, int, error) {
+	pageSize := int(size)
+	switch {
+	case pageSize < 0:
+		return 0, 0, status.Errorf(codes.InvalidArgument, "page size must not be negative: %d", pageSize)
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	offset, err := decodePageToken(token)
+	if err != nil {
+		return 0, 0, status.Errorf(codes.InvalidArgument, "invalid page token: %v", err)
+	}
+
+	return pageSize, offset, nil
+}
+
+func imageInfo(record storage.ImagesInfo) *pb.ImageInfo {
+	return &pb.ImageInfo{
+		ImageId:     record.ImageId,
+		Filename:    record.Filename,
+		CreatedAt:   timestamp(record.CreatedAt),
+		ChangedAt:   timestamp(record.ChangedAt),
+		Size:        record.Size,
+		ContentType: record.ContentType,
+		Sha256:      record.Checksum,
+		Tags:        record.Tags,
+		Version:     int32(record.Version),
+		DeletedAt:   timestamp(record.DeletedAt),
+		Namespace:   record.Namespace,
+	}
+}
+
+// timestamp converts t for the API, leaving a zero time unset.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func sortOrder(order pb.SortOrder) storage.SortOrder {
+	switch order {
+	case pb.SortOrder_SORT_BY_CREATED:
+		return storage.SortByCreated
+	case pb.SortOrder_SORT_BY_CHANGED:
+		return storage.SortByChanged
+	default:
+		return storage.SortByName
+	}
+}
+
+// Page tokens are opaque to clients; they encode the offset of the next page.
+func encodePageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodePageToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, strconv.ErrRange
+	}
+
+	return offset, nil
+}
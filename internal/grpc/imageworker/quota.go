@@ -0,0 +1,74 @@
+package imageworker
+
+import (
+	"context"
+	"log"
+
+	"github.com/Niiazgulov/tages.git/internal/grpc/limiter"
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// namespace returns the namespace charged for the images the caller saves: its client id if
+// it came with the key configured for it, or storage.DefaultNamespace.
+func (s *serverAPI) namespace(ctx context.Context) string {
+	if id := limiter.ClientID(ctx, s.clientKeys); id != "" {
+		return id
+	}
+
+	return storage.DefaultNamespace
+}
+
+// checkQuota refuses an upload of size bytes to filename before any of its data is received
+// if it cannot fit into the quotas.
+func (s *serverAPI) checkQuota(filename, namespace string, size int64) error {
+	err := s.imgProcessor.CheckQuota(storage.ImagesInfo{Filename: filename, Namespace: namespace, Size: size}, s.repo)
+	if err != nil {
+		return toStatus("cannot accept image", err)
+	}
+
+	return nil
+}
+
+// warnUsage logs the soft limits reached by namespace or the whole store.
+func (s *serverAPI) warnUsage(namespace string) {
+	report, err := s.imgProcessor.Usage(namespace, s.repo)
+	if err != nil {
+		log.Printf("cannot check usage of namespace %q: %v", namespace, err)
+		return
+	}
+	for _, reached := range report.SoftLimitsReached() {
+		log.Printf("quota warning: %s", reached)
+	}
+}
+
+func (s *serverAPI) GetUsage(ctx context.Context, req *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	ns := s.namespace(ctx)
+	if req.GetNamespace() != "" && req.GetNamespace() != ns {
+		return nil, logError(status.Errorf(codes.PermissionDenied, "cannot get usage of namespace %q from namespace %q", req.GetNamespace(), ns))
+	}
+
+	report, err := s.imgProcessor.Usage(ns, s.repo)
+	if err != nil {
+		return nil, logError(toStatus("cannot get usage", err))
+	}
+
+	return &pb.GetUsageResponse{
+		Namespace: report.Namespace,
+		Usage:     usage(report.Usage, report.Quota),
+		Total:     usage(report.Total, report.TotalQuota),
+	}, nil
+}
+
+func usage(used storage.Usage, quota storage.Quota) *pb.Usage {
+	return &pb.Usage{
+		Bytes:     used.Bytes,
+		Files:     used.Files,
+		MaxBytes:  quota.MaxBytes,
+		MaxFiles:  quota.MaxFiles,
+		SoftBytes: quota.SoftBytes,
+		SoftFiles: quota.SoftFiles,
+	}
+}
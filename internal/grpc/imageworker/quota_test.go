@@ -0,0 +1,268 @@
+package imageworker_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/Niiazgulov/tages.git/internal/storage/blob"
+	"github.com/Niiazgulov/tages.git/internal/storage/storagetest"
+	"github.com/Niiazgulov/tages.git/protos"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var clientKeys = map[string]string{"alice": "alice-key", "bob": "bob-key"}
+
+// startQuotaServer serves a store keeping content in blobs, where alice may store 1 MiB and
+// every other namespace 64 bytes.
+func startQuotaServer(t *testing.T, blobs blob.Store) pb.ImageWorkerClient {
+	t.Helper()
+
+	store := storage.NewImageStore(blobs, t.TempDir())
+	store.SetQuotas(storage.Quotas{
+		Namespace:  storage.Quota{MaxBytes: 64},
+		Namespaces: map[string]storage.Quota{"alice": {MaxBytes: 1 << 20}},
+	})
+
+	return startServer(t, store, storagetest.Bolt(t), clientKeys)
+}
+
+// as returns a context identifying the caller as id with key.
+func as(id, key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), protos.ClientIDKey, id, protos.ClientKeyKey, key)
+}
+
+// upload saves data as filename and returns the status code the upload ended with.
+func upload(ctx context.Context, client pb.ImageWorkerClient, filename, data string) codes.Code {
+	stream, err := client.UploadImage(ctx)
+	if err != nil {
+		return status.Code(err)
+	}
+
+	header := &pb.ImageHeader{Filename: filename, Size: int64(len(data))}
+	err = stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_Header{Header: header}})
+	// A refused upload is only reported by CloseAndRecv; Send just sees the stream end.
+	for chunk := []byte(data); err == nil && len(chunk) > 0; chunk = chunk[min(len(chunk), 16):] {
+		err = stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_ImageData{ImageData: chunk[:min(len(chunk), 16)]}})
+	}
+	if err != nil && err != io.EOF {
+		return status.Code(err)
+	}
+
+	_, err = stream.CloseAndRecv()
+	return status.Code(err)
+}
+
+func TestNamespaceNeedsKey(t *testing.T) {
+	client := startQuotaServer(t, blob.NewMemory())
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"anonymous", context.Background(), storage.DefaultNamespace},
+		{"matching key", as("alice", "alice-key"), "alice"},
+		{"no key", as("alice", ""), storage.DefaultNamespace},
+		{"wrong key", as("alice", "bob-key"), storage.DefaultNamespace},
+		{"unknown client", as("mallory", "alice-key"), storage.DefaultNamespace},
+	}
+	for _, tt := range tests {
+		res, err := client.GetUsage(tt.ctx, &pb.GetUsageRequest{})
+		if err != nil {
+			t.Fatalf("%s: GetUsage: %v", tt.name, err)
+		}
+		if res.GetNamespace() != tt.want {
+			t.Errorf("%s: caller is in namespace %q, want %q", tt.name, res.GetNamespace(), tt.want)
+		}
+	}
+}
+
+func TestQuotaRefusesUploadBeforeStoring(t *testing.T) {
+	blobs := blob.NewMemory()
+	client := startQuotaServer(t, blobs)
+	data := strings.Repeat("x", 1000)
+
+	// Claiming alice's id without her key does not get her quota.
+	if code := upload(as("alice", "guess"), client, "big.bin", data); code != codes.ResourceExhausted {
+		t.Errorf("upload over the default quota ended with %v, want ResourceExhausted", code)
+	}
+	if code := upload(as("bob", "bob-key"), client, "big.bin", data); code != codes.ResourceExhausted {
+		t.Errorf("upload over bob's quota ended with %v, want ResourceExhausted", code)
+	}
+
+	var stored []string
+	blobs.List("", func(info blob.Info) error {
+		stored = append(stored, info.Name)
+		return nil
+	})
+	if len(stored) != 0 {
+		t.Errorf("refused uploads left blobs behind: %v", stored)
+	}
+
+	if code := upload(as("alice", "alice-key"), client, "big.bin", data); code != codes.OK {
+		t.Errorf("upload within alice's quota ended with %v, want OK", code)
+	}
+}
+
+func TestUploadKeepsOtherNamespaces(t *testing.T) {
+	client := startQuotaServer(t, blob.NewMemory())
+
+	if code := upload(as("alice", "alice-key"), client, "a.txt", "alice's"); code != codes.OK {
+		t.Fatalf("upload ended with %v", code)
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"bob":       as("bob", "bob-key"),
+		"anonymous": context.Background(),
+	} {
+		if code := upload(ctx, client, "a.txt", "overwritten"); code != codes.PermissionDenied {
+			t.Errorf("%s replacing alice's image ended with %v, want PermissionDenied", name, code)
+		}
+	}
+
+	if code := upload(as("alice", "alice-key"), client, "a.txt", "alice's again"); code != codes.OK {
+		t.Errorf("alice replacing her own image ended with %v, want OK", code)
+	}
+	stream, err := client.StreamImage(context.Background(), &pb.DownloadRequest{Filename: "a.txt"})
+	if err != nil {
+		t.Fatalf("StreamImage: %v", err)
+	}
+	var got []byte
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("download failed: %v", err)
+		}
+		got = append(got, msg.GetImageData()...)
+	}
+	if string(got) != "alice's again" {
+		t.Errorf("a.txt holds %q, want alice's last upload", got)
+	}
+}
+
+// byName refers to the image saved as filename.
+func byName(filename string) *pb.ImageRef {
+	return &pb.ImageRef{Ref: &pb.ImageRef_Filename{Filename: filename}}
+}
+
+func TestChangesKeepOtherNamespaces(t *testing.T) {
+	client := startQuotaServer(t, blob.NewMemory())
+	alice, bob := as("alice", "alice-key"), as("bob", "bob-key")
+
+	for _, data := range []string{"alice's first", "alice's"} {
+		if code := upload(alice, client, "a.txt", data); code != codes.OK {
+			t.Fatalf("alice's upload ended with %v", code)
+		}
+	}
+	if code := upload(bob, client, "b.txt", "bob's"); code != codes.OK {
+		t.Fatalf("bob's upload ended with %v", code)
+	}
+
+	overwrite := pb.ConflictPolicy_CONFLICT_OVERWRITE
+	calls := map[string]func(ctx context.Context) error{
+		"delete": func(ctx context.Context) error {
+			_, err := client.DeleteImage(ctx, &pb.DeleteImageRequest{Image: byName("a.txt")})
+			return err
+		},
+		"rename": func(ctx context.Context) error {
+			_, err := client.RenameImage(ctx, &pb.RenameImageRequest{Image: byName("a.txt"), NewFilename: "z.txt"})
+			return err
+		},
+		"rename over": func(ctx context.Context) error {
+			_, err := client.RenameImage(ctx, &pb.RenameImageRequest{Image: byName("b.txt"), NewFilename: "a.txt", OnConflict: overwrite})
+			return err
+		},
+		"copy": func(ctx context.Context) error {
+			_, err := client.CopyImage(ctx, &pb.CopyImageRequest{Image: byName("a.txt"), NewFilename: "z.txt"})
+			return err
+		},
+		"copy over": func(ctx context.Context) error {
+			_, err := client.CopyImage(ctx, &pb.CopyImageRequest{Image: byName("b.txt"), NewFilename: "a.txt", OnConflict: overwrite})
+			return err
+		},
+		"restore version": func(ctx context.Context) error {
+			_, err := client.RestoreVersion(ctx, &pb.RestoreVersionRequest{Image: byName("a.txt"), Version: 1})
+			return err
+		},
+	}
+	for name, call := range calls {
+		if code := status.Code(call(bob)); code != codes.PermissionDenied {
+			t.Errorf("bob's %s of alice's image ended with %v, want PermissionDenied", name, code)
+		}
+	}
+	if code := status.Code(calls["copy"](context.Background())); code != codes.PermissionDenied {
+		t.Errorf("anonymous copy of alice's image ended with %v, want PermissionDenied", code)
+	}
+
+	// A copy is charged to whoever makes it, so the default namespace cannot grow through alice's quota.
+	anonymous := context.Background()
+	if code := upload(anonymous, client, "d.txt", strings.Repeat("d", 40)); code != codes.OK {
+		t.Fatalf("anonymous upload ended with %v", code)
+	}
+	_, err := client.CopyImage(anonymous, &pb.CopyImageRequest{Image: byName("d.txt"), NewFilename: "d2.txt"})
+	if code := status.Code(err); code != codes.ResourceExhausted {
+		t.Errorf("anonymous copy over the default quota ended with %v, want ResourceExhausted", code)
+	}
+
+	for _, name := range []string{"restore version", "rename", "copy", "delete"} {
+		if err := calls[name](alice); err != nil {
+			t.Errorf("alice's %s of her own image: %v", name, err)
+		}
+		if name == "rename" {
+			// Put a.txt back for the copy and the delete.
+			if _, err := client.RenameImage(alice, &pb.RenameImageRequest{Image: byName("z.txt"), NewFilename: "a.txt"}); err != nil {
+				t.Fatalf("RenameImage back: %v", err)
+			}
+		}
+	}
+}
+
+func TestTrashKeepsOtherNamespaces(t *testing.T) {
+	client := startQuotaServer(t, blob.NewMemory())
+	alice, bob := as("alice", "alice-key"), as("bob", "bob-key")
+
+	if code := upload(alice, client, "a.txt", "alice's"); code != codes.OK {
+		t.Fatalf("upload ended with %v", code)
+	}
+	if _, err := client.DeleteImage(alice, &pb.DeleteImageRequest{Image: byName("a.txt")}); err != nil {
+		t.Fatalf("DeleteImage: %v", err)
+	}
+
+	trash, err := client.ListTrash(bob, &pb.ListTrashRequest{})
+	if err != nil || len(trash.GetImages()) != 0 {
+		t.Errorf("bob's trash = %v, %v, want it empty", trash.GetImages(), err)
+	}
+	_, err = client.RestoreImage(bob, &pb.RestoreImageRequest{Image: byName("a.txt")})
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Errorf("bob restoring alice's image ended with %v, want PermissionDenied", code)
+	}
+	emptied, err := client.EmptyTrash(bob, &pb.EmptyTrashRequest{})
+	if err != nil || emptied.GetPurged() != 0 {
+		t.Errorf("bob emptying his trash = %d, %v, want nothing purged", emptied.GetPurged(), err)
+	}
+	_, err = client.GetUsage(bob, &pb.GetUsageRequest{Namespace: "alice"})
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Errorf("bob reading alice's usage ended with %v, want PermissionDenied", code)
+	}
+
+	trash, err = client.ListTrash(alice, &pb.ListTrashRequest{})
+	if err != nil || len(trash.GetImages()) != 1 {
+		t.Fatalf("alice's trash = %v, %v, want a.txt", trash.GetImages(), err)
+	}
+	if _, err := client.RestoreImage(alice, &pb.RestoreImageRequest{Image: byName("a.txt")}); err != nil {
+		t.Errorf("alice cannot restore her own image: %v", err)
+	}
+	usage, err := client.GetUsage(alice, &pb.GetUsageRequest{Namespace: "alice"})
+	if err != nil || usage.GetUsage().GetFiles() != 1 {
+		t.Errorf("alice's usage = %v, %v, want one image", usage.GetUsage(), err)
+	}
+}
@@ -1,12 +1,18 @@
 package imageworker
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Niiazgulov/tages.git/internal/grpc/limiter"
 	"github.com/Niiazgulov/tages.git/internal/storage"
 	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
 	"google.golang.org/grpc"
@@ -18,10 +24,14 @@ type serverAPI struct {
 	pb.UnimplementedImageWorkerServer
 	imgProcessor storage.ImageProcessor
 	repo         storage.ImageDB
+	// clientKeys maps client ids to the keys that put a client into the namespace of its id.
+	clientKeys map[string]string
+	// activeUploads holds the IDs of upload sessions that are being written right now.
+	activeUploads sync.Map
 }
 
-func Register(gRPCServer *grpc.Server, imgProcessor storage.ImageProcessor, repo storage.ImageDB) {
-	pb.RegisterImageWorkerServer(gRPCServer, &serverAPI{imgProcessor: imgProcessor, repo: repo})
+func Register(gRPCServer *grpc.Server, imgProcessor storage.ImageProcessor, repo storage.ImageDB, clientKeys map[string]string) {
+	pb.RegisterImageWorkerServer(gRPCServer, &serverAPI{imgProcessor: imgProcessor, repo: repo, clientKeys: clientKeys})
 }
 
 func logError(err error) error {
@@ -31,65 +41,228 @@ func logError(err error) error {
 	return err
 }
 
-const maxImageSize = 1 << 20
+const (
+	maxImageSize      = 512 << 20
+	downloadChunkSize = 64 << 10
+	// maxDownloadMessage leaves room for framing under the default 4 MB gRPC message limit.
+	maxDownloadMessage = 4<<20 - 1<<10
+)
+
+// MethodPool assigns the ImageWorker methods to the concurrency pools of the limiter.
+func MethodPool(fullMethod string) (limiter.Pool, bool) {
+	switch fullMethod {
+	case pb.ImageWorker_UploadImage_FullMethodName, pb.ImageWorker_DownloadImage_FullMethodName,
+		pb.ImageWorker_StreamImage_FullMethodName, pb.ImageWorker_CopyImage_FullMethodName:
+		return limiter.Transfer, true
+	case pb.ImageWorker_InformImage_FullMethodName, pb.ImageWorker_ListImages_FullMethodName,
+		pb.ImageWorker_StatImage_FullMethodName, pb.ImageWorker_DeleteImage_FullMethodName,
+		pb.ImageWorker_RenameImage_FullMethodName, pb.ImageWorker_StartUpload_FullMethodName,
+		pb.ImageWorker_GetUploadStatus_FullMethodName, pb.ImageWorker_ListVersions_FullMethodName,
+		pb.ImageWorker_RestoreVersion_FullMethodName, pb.ImageWorker_ListTrash_FullMethodName,
+		pb.ImageWorker_RestoreImage_FullMethodName, pb.ImageWorker_EmptyTrash_FullMethodName,
+		pb.ImageWorker_GetUsage_FullMethodName:
+		return limiter.Listing, true
+	default:
+		return "", false
+	}
+}
 
 func (server *serverAPI) UploadImage(stream pb.ImageWorker_UploadImageServer) error {
-	limiter := make(chan struct{}, 10)
-	limiter <- struct{}{}
+	err := contextError(stream.Context())
+	if err != nil {
+		return err
+	}
 
-	var newImage storage.ImagesInfo
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return logError(status.Error(codes.InvalidArgument, "upload stream is empty"))
+	}
+	if err != nil {
+		return logError(toStatus("cannot receive chunk data", err))
+	}
 
-	for {
-		err := contextError(stream.Context())
-		if err != nil {
-			return err
-		}
+	if resume := req.GetResume(); resume != nil {
+		return server.resumeUpload(stream, resume)
+	}
 
-		req, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
-		}
+	header := req.GetHeader()
+	if header == nil {
+		return logError(status.Error(codes.InvalidArgument, "first upload message must be a header or a resume position"))
+	}
+	err = validateHeader(header)
+	if err != nil {
+		return logError(err)
+	}
 
-		chunk := req.GetImageData()
-		size := len(chunk)
-		newImage.Filename = req.GetFilename()
+	var newImage storage.ImagesInfo
+	newImage.Filename = header.GetFilename()
+	newImage.Tags = header.GetTags()
+	newImage.ContentType = header.GetContentType()
+	newImage.Size = header.GetSize()
+	newImage.CreatedAt = time.Now()
+	newImage.Namespace = server.namespace(stream.Context())
+
+	err = server.checkQuota(newImage.Filename, newImage.Namespace, header.GetSize())
+	if err != nil {
+		return logError(err)
+	}
 
-		imageSize += size
-		if imageSize > maxImageSize {
-			return logError(status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", imageSize, maxImageSize))
+	if header.GetSha256() != "" {
+		saved, err := server.saveKnownImage(stream, header, newImage)
+		if err != nil || saved {
+			return err
 		}
+	}
 
-		_, err = imageData.Write(chunk)
-		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot write chunk data: %v", err))
-		}
+	reader := &uploadReader{stream: stream, header: header, hash: sha256.New()}
+	newImage.ImageId, err = server.imgProcessor.SaveNewImage(reader, newImage, server.repo)
+	if reader.err != nil {
+		return logError(reader.err)
 	}
-	var err error
-	newImage.CreatedAt = time.Now().Format(time.RFC850)
-	newImage.ImageId, err = server.imgProcessor.SaveNewImage(imageData, newImage, server.repo)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot save image to the store: %v", err))
+		return logError(toStatus("cannot save image to the store", err))
 	}
 
-	<-limiter
-
 	res := &pb.UploadResponse{
 		ImageId:   newImage.ImageId,
 		Filename:  newImage.Filename,
-		CreatedAt: newImage.CreatedAt,
+		CreatedAt: timestamp(newImage.CreatedAt),
+		Received:  reader.size,
+	}
+
+	err = stream.SendAndClose(res)
+	if err != nil {
+		return logError(toStatus("cannot send response", err))
+	}
+
+	log.Printf("saved image %s at: %s", newImage.Filename, newImage.CreatedAt.Format(time.RFC850))
+	server.warnUsage(newImage.Namespace)
+	return nil
+}
+
+// saveKnownImage saves the image of an upload whose header declares content the store already
+// has and answers the stream without reading any image data. It reports false if the content
+// is not stored, leaving the stream for the upload to go on.
+func (server *serverAPI) saveKnownImage(stream pb.ImageWorker_UploadImageServer, header *pb.ImageHeader, newImage storage.ImagesInfo) (bool, error) {
+	newImage.Size = header.GetSize()
+	newImage.Checksum = header.GetSha256()
+
+	imageID, saved, err := server.imgProcessor.SaveKnownImage(newImage, server.repo)
+	if err != nil {
+		return false, logError(toStatus("cannot save image to the store", err))
+	}
+	if !saved {
+		return false, nil
 	}
 
+	res := &pb.UploadResponse{
+		ImageId:      imageID,
+		Filename:     newImage.Filename,
+		CreatedAt:    timestamp(newImage.CreatedAt),
+		Received:     newImage.Size,
+		Deduplicated: true,
+	}
 	err = stream.SendAndClose(res)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+		return true, logError(toStatus("cannot send response", err))
+	}
+
+	log.Printf("saved image %s from stored content at: %s", newImage.Filename, newImage.CreatedAt.Format(time.RFC850))
+	server.warnUsage(newImage.Namespace)
+	return true, nil
+}
+
+// validateHeader checks an upload header and normalizes its filename in place.
+func validateHeader(header *pb.ImageHeader) error {
+	filename, err := storage.CleanFilename(header.GetFilename())
+	if err != nil {
+		return toStatus("invalid header", err)
+	}
+	header.Filename = filename
+
+	if header.GetSize() < 0 {
+		return status.Errorf(codes.InvalidArgument, "declared image size %d must not be negative", header.GetSize())
+	}
+	if header.GetSize() > maxImageSize {
+		return toStatus("invalid header", fmt.Errorf("declared size %d, limit %d: %w", header.GetSize(), maxImageSize, storage.ErrImgTooLarge))
+	}
+
+	return nil
+}
+
+// uploadReader exposes the image data of an upload stream as an io.Reader and checks it
+// against the declared header. The first failure is kept in err as a gRPC status so the
+// handler can return it as is; a mismatch with the header is reported before io.EOF so
+// the store never commits the image. Without hash it only enforces the declared size,
+// which is how parts of an upload session are received.
+type uploadReader struct {
+	stream pb.ImageWorker_UploadImageServer
+	header *pb.ImageHeader
+	hash   hash.Hash
+	chunk  []byte
+	size   int64
+	err    error
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		if err := contextError(r.stream.Context()); err != nil {
+			r.err = err
+			return 0, err
+		}
+
+		req, err := r.stream.Recv()
+		if err == io.EOF {
+			if r.hash != nil {
+				r.err = verifyContent(r.size, r.hash, r.header)
+			}
+			if r.err != nil {
+				return 0, r.err
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			r.err = toStatus("cannot receive chunk data", err)
+			return 0, r.err
+		}
+		if req.GetHeader() != nil {
+			r.err = status.Error(codes.InvalidArgument, "header may only be sent as the first upload message")
+			return 0, r.err
+		}
+
+		r.chunk = req.GetImageData()
+		r.size += int64(len(r.chunk))
+		if r.size > r.header.GetSize() {
+			r.err = status.Errorf(codes.DataLoss, "received more data than declared: %d > %d", r.size, r.header.GetSize())
+			return 0, r.err
+		}
+		if r.hash != nil {
+			r.hash.Write(r.chunk)
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+
+	return n, nil
+}
+
+// verifyContent checks received data against the size and checksum declared in header.
+func verifyContent(size int64, sum hash.Hash, header *pb.ImageHeader) error {
+	if size != header.GetSize() {
+		return status.Errorf(codes.DataLoss, "received %d bytes, declared %d", size, header.GetSize())
+	}
+	if want := header.GetSha256(); want != "" {
+		got := hex.EncodeToString(sum.Sum(nil))
+		if !strings.EqualFold(got, want) {
+			return status.Errorf(codes.DataLoss, "checksum mismatch: received %s, declared %s", got, want)
+		}
 	}
 
-	log.Printf("saved image %s at: %s", newImage.Filename, newImage.CreatedAt)
 	return nil
 }
 
@@ -105,9 +278,6 @@ func contextError(ctx context.Context) error {
 }
 
 func (s *serverAPI) InformImage(stream pb.ImageWorker_InformImageServer) error {
-	limiter := make(chan struct{}, 100)
-	limiter <- struct{}{}
-
 	err := contextError(stream.Context())
 	if err != nil {
 		return err
@@ -115,19 +285,17 @@ func (s *serverAPI) InformImage(stream pb.ImageWorker_InformImageServer) error {
 
 	_, err = stream.Recv()
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot receive request: %v", err))
+		return logError(toStatus("cannot receive request", err))
 	}
 
 	records, err := s.imgProcessor.ImagesView(s.repo)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send resquest to get image info (server): %v", err))
+		return logError(toStatus("cannot get image info", err))
 	}
 
-	<-limiter
-
 	var resp []*pb.InfoSlice
 	for _, v := range records {
-		entry := &pb.InfoSlice{Value: []string{v.Filename, v.CreatedAt, v.ChangedAt}}
+		entry := &pb.InfoSlice{Value: []string{v.Filename, v.CreatedAt.Format(time.RFC850), v.ChangedAt.Format(time.RFC850)}}
 		resp = append(resp, entry)
 	}
 
@@ -137,7 +305,7 @@ func (s *serverAPI) InformImage(stream pb.ImageWorker_InformImageServer) error {
 
 	err = stream.SendAndClose(res)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+		return logError(toStatus("cannot send response", err))
 	}
 
 	log.Println("all image's info successfully sended to client")
@@ -145,10 +313,21 @@ func (s *serverAPI) InformImage(stream pb.ImageWorker_InformImageServer) error {
 	return nil
 }
 
-func (s *serverAPI) DownloadImage(stream pb.ImageWorker_DownloadImageServer) error {
-	limiter := make(chan struct{}, 10)
-	limiter <- struct{}{}
+// openImage opens the version and byte range of an image a download asks for.
+func (s *serverAPI) openImage(req *pb.DownloadRequest) (io.ReadCloser, storage.ImageStat, error) {
+	if req.GetVersion() < 0 {
+		return nil, storage.ImageStat{}, status.Errorf(codes.InvalidArgument, "version must not be negative: %d", req.GetVersion())
+	}
 
+	file, stat, err := s.imgProcessor.OpenImageVersion(req.GetFilename(), int(req.GetVersion()), req.GetOffset(), req.GetLength(), s.repo)
+	if err != nil {
+		return nil, storage.ImageStat{}, toStatus("cannot open image", err)
+	}
+
+	return file, stat, nil
+}
+
+func (s *serverAPI) DownloadImage(stream pb.ImageWorker_DownloadImageServer) error {
 	err := contextError(stream.Context())
 	if err != nil {
 		return err
@@ -156,27 +335,92 @@ func (s *serverAPI) DownloadImage(stream pb.ImageWorker_DownloadImageServer) err
 
 	req, err := stream.Recv()
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
+		return logError(toStatus("cannot receive chunk data", err))
 	}
 
-	filename := req.GetFilename()
-	img, err := s.imgProcessor.GetImage(filename)
+	file, stat, err := s.openImage(req)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send resquest to get image (server): %v", err))
+		return logError(err)
 	}
+	defer file.Close()
 
-	<-limiter
+	if stat.Length > maxDownloadMessage {
+		return logError(status.Errorf(codes.OutOfRange, "range of %d bytes does not fit into one message (max %d), use StreamImage or a smaller length", stat.Length, maxDownloadMessage))
+	}
+
+	img, err := io.ReadAll(file)
+	if err != nil {
+		return logError(toStatus("cannot read image", err))
+	}
 
 	res := &pb.DownloadResponse{
 		ImageData: img,
+		Offset:    stat.Offset,
+		TotalSize: stat.Size,
+		Version:   int32(stat.Version),
 	}
 
 	err = stream.SendAndClose(res)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+		return logError(toStatus("cannot send response", err))
 	}
 
 	log.Println("image successfully sended to client")
 
 	return nil
 }
+
+func (s *serverAPI) StreamImage(req *pb.DownloadRequest, stream pb.ImageWorker_StreamImageServer) error {
+	err := contextError(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	file, stat, err := s.openImage(req)
+	if err != nil {
+		return logError(err)
+	}
+	defer file.Close()
+
+	header := &pb.DownloadChunk{
+		Data: &pb.DownloadChunk_Header{Header: &pb.ImageHeader{
+			Filename:    stat.Filename,
+			Size:        stat.Size,
+			ContentType: stat.ContentType,
+			Sha256:      stat.Checksum,
+			Offset:      stat.Offset,
+			Length:      stat.Length,
+			Version:     int32(stat.Version),
+		}},
+	}
+	err = stream.Send(header)
+	if err != nil {
+		return logError(toStatus("cannot send image header", err))
+	}
+
+	buffer := make([]byte, downloadChunkSize)
+	for {
+		err := contextError(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		n, err := file.Read(buffer)
+		if n > 0 {
+			chunk := &pb.DownloadChunk{Data: &pb.DownloadChunk_ImageData{ImageData: buffer[:n]}}
+			if err := stream.Send(chunk); err != nil {
+				return logError(toStatus("cannot send chunk data", err))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return logError(toStatus("cannot read image chunk", err))
+		}
+	}
+
+	log.Printf("image %s successfully streamed to client", stat.Filename)
+
+	return nil
+}
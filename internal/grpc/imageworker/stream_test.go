@@ -0,0 +1,171 @@
+package imageworker_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	imageworkergrpc "github.com/Niiazgulov/tages.git/internal/grpc/imageworker"
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/Niiazgulov/tages.git/internal/storage/storagetest"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startServer serves the image worker on store and repo over an in-memory connection.
+func startServer(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB, clientKeys map[string]string) pb.ImageWorkerClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	imageworkergrpc.Register(server, store, repo, clientKeys)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("cannot dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewImageWorkerClient(conn)
+}
+
+// heapPeak samples the heap in use until stop is called and then returns the highest sample.
+func heapPeak() (stop func() uint64) {
+	var (
+		wg   sync.WaitGroup
+		peak uint64
+	)
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > peak {
+				peak = stats.HeapInuse
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() uint64 {
+		close(done)
+		wg.Wait()
+		return peak
+	}
+}
+
+func TestStreamingMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams 256 MiB through the server")
+	}
+
+	const (
+		size      = 256 << 20
+		chunkSize = 64 << 10
+		// Far below the image size: neither side may hold the whole image.
+		maxGrowth = 64 << 20
+	)
+
+	client := startServer(t, storage.NewDiskImageStore(t.TempDir()), storagetest.Bolt(t), nil)
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	stop := heapPeak()
+
+	// The image is generated while it is sent, so the test itself holds one chunk at a time.
+	stream, err := client.UploadImage(context.Background())
+	if err != nil {
+		t.Fatalf("UploadImage: %v", err)
+	}
+	header := &pb.ImageHeader{Filename: "large.bin", Size: size, ContentType: "application/octet-stream"}
+	if err := stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_Header{Header: header}}); err != nil {
+		t.Fatalf("cannot send header: %v", err)
+	}
+	sent := sha256.New()
+	data := io.TeeReader(io.LimitReader(rand.New(rand.NewSource(1)), size), sent)
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(data, chunk)
+		if n > 0 {
+			if err := stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_ImageData{ImageData: chunk[:n]}}); err != nil {
+				t.Fatalf("cannot send chunk: %v", err)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("cannot generate image: %v", err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if res.GetReceived() != size {
+		t.Errorf("server received %d bytes, want %d", res.GetReceived(), size)
+	}
+
+	download, err := client.StreamImage(context.Background(), &pb.DownloadRequest{Filename: "large.bin"})
+	if err != nil {
+		t.Fatalf("StreamImage: %v", err)
+	}
+	received := sha256.New()
+	var got int64
+	var checksum string
+	for {
+		msg, err := download.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("download failed: %v", err)
+		}
+		if h := msg.GetHeader(); h != nil {
+			checksum = h.GetSha256()
+			continue
+		}
+		got += int64(len(msg.GetImageData()))
+		received.Write(msg.GetImageData())
+	}
+
+	peak := stop()
+
+	want := hex.EncodeToString(sent.Sum(nil))
+	if got != size || hex.EncodeToString(received.Sum(nil)) != want {
+		t.Errorf("downloaded %d bytes that do not match the %d uploaded", got, size)
+	}
+	if checksum != want {
+		t.Errorf("download header has checksum %s, want %s", checksum, want)
+	}
+	if growth := int64(peak) - int64(before.HeapInuse); growth > maxGrowth {
+		t.Errorf("heap grew by %d MiB while streaming %d MiB, want at most %d MiB", growth>>20, size>>20, maxGrowth>>20)
+	}
+	t.Logf("heap grew by at most %d MiB while streaming %d MiB each way", (int64(peak)-int64(before.HeapInuse))>>20, size>>20)
+}
@@ -0,0 +1,66 @@
+package imageworker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+)
+
+func (s *serverAPI) ListTrash(ctx context.Context, req *pb.ListTrashRequest) (*pb.ListTrashResponse, error) {
+	pageSize, offset, err := page(req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	records, err := s.repo.ListTrash(storage.ListOptions{
+		Prefix:    req.GetFilenamePrefix(),
+		Namespace: s.namespace(ctx),
+		Offset:    offset,
+		Limit:     pageSize + 1,
+	})
+	if err != nil {
+		return nil, logError(toStatus("cannot list trash", err))
+	}
+
+	res := &pb.ListTrashResponse{}
+	if len(records) > pageSize {
+		records = records[:pageSize]
+		res.NextPageToken = encodePageToken(offset + pageSize)
+	}
+	for _, record := range records {
+		res.Images = append(res.Images, imageInfo(record))
+	}
+
+	return res, nil
+}
+
+func (s *serverAPI) RestoreImage(ctx context.Context, req *pb.RestoreImageRequest) (*pb.RestoreImageResponse, error) {
+	key, err := imageKey(req.GetImage())
+	if err != nil {
+		return nil, logError(err)
+	}
+	key.Namespace = s.namespace(ctx)
+
+	restored, err := s.repo.RestoreInfo(key)
+	if err != nil {
+		return nil, logError(toStatus("cannot restore image", err))
+	}
+
+	log.Printf("restored image %s from the trash", restored.Filename)
+
+	return &pb.RestoreImageResponse{Image: imageInfo(restored)}, nil
+}
+
+func (s *serverAPI) EmptyTrash(ctx context.Context, req *pb.EmptyTrashRequest) (*pb.EmptyTrashResponse, error) {
+	n, err := s.repo.PurgeTrash(s.namespace(ctx), time.Now())
+	if err != nil {
+		return nil, logError(toStatus("cannot empty trash", err))
+	}
+
+	log.Printf("purged %d images from the trash", n)
+
+	return &pb.EmptyTrashResponse{Purged: int32(n)}, nil
+}
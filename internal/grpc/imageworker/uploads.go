@@ -0,0 +1,209 @@
+package imageworker
+
+import (
+	"context"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"log"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (server *serverAPI) StartUpload(ctx context.Context, req *pb.StartUploadRequest) (*pb.StartUploadResponse, error) {
+	header := req.GetHeader()
+	if header == nil {
+		return nil, logError(status.Error(codes.InvalidArgument, "header is required"))
+	}
+	err := validateHeader(header)
+	if err != nil {
+		return nil, logError(err)
+	}
+	ns := server.namespace(ctx)
+	err = server.checkQuota(header.GetFilename(), ns, header.GetSize())
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	uploadID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, logError(status.Errorf(codes.Internal, "cannot generate upload id: %v", err))
+	}
+
+	session := storage.UploadSession{
+		UploadID:    uploadID.String(),
+		Filename:    header.GetFilename(),
+		Size:        header.GetSize(),
+		ContentType: header.GetContentType(),
+		Checksum:    header.GetSha256(),
+		Tags:        header.GetTags(),
+		Namespace:   ns,
+		CreatedAt:   time.Now(),
+	}
+	err = server.repo.SaveUploadSession(session)
+	if err != nil {
+		return nil, logError(toStatus("cannot save upload session", err))
+	}
+
+	log.Printf("started upload %s of image %s", session.UploadID, session.Filename)
+
+	return &pb.StartUploadResponse{UploadId: session.UploadID}, nil
+}
+
+// getUploadSession returns the upload session uploadID if the caller started it, so that
+// nobody else can look at, add to or finish an upload charged to the caller's namespace.
+func (server *serverAPI) getUploadSession(ctx context.Context, uploadID string) (storage.UploadSession, error) {
+	session, err := server.repo.GetUploadSession(uploadID)
+	if err != nil {
+		return storage.UploadSession{}, toStatus("cannot get upload session", err)
+	}
+	if session.Namespace != server.namespace(ctx) {
+		return storage.UploadSession{}, status.Errorf(codes.PermissionDenied, "upload %s was started in another namespace", uploadID)
+	}
+
+	return session, nil
+}
+
+func (server *serverAPI) GetUploadStatus(ctx context.Context, req *pb.UploadStatusRequest) (*pb.UploadStatusResponse, error) {
+	session, err := server.getUploadSession(ctx, req.GetUploadId())
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	received, err := server.imgProcessor.PartialSize(session.UploadID)
+	if err != nil {
+		return nil, logError(toStatus("cannot get received size", err))
+	}
+
+	return &pb.UploadStatusResponse{
+		UploadId:  session.UploadID,
+		Filename:  session.Filename,
+		Size:      session.Size,
+		Received:  received,
+		UpdatedAt: timestamp(session.UpdatedAt),
+	}, nil
+}
+
+// resumeUpload appends the rest of the stream to an upload session and stores the image
+// once all of its declared bytes have arrived.
+func (server *serverAPI) resumeUpload(stream pb.ImageWorker_UploadImageServer, resume *pb.ResumeUpload) error {
+	session, err := server.getUploadSession(stream.Context(), resume.GetUploadId())
+	if err != nil {
+		return logError(err)
+	}
+
+	if _, busy := server.activeUploads.LoadOrStore(session.UploadID, struct{}{}); busy {
+		return logError(status.Errorf(codes.Aborted, "upload %s is already in progress", session.UploadID))
+	}
+	defer server.activeUploads.Delete(session.UploadID)
+
+	offset := resume.GetOffset()
+	if offset < 0 || offset > session.Size {
+		return logError(status.Errorf(codes.OutOfRange, "offset %d is out of range [0, %d]", offset, session.Size))
+	}
+
+	reader := &uploadReader{stream: stream, header: &pb.ImageHeader{Size: session.Size}, size: offset}
+	received, err := server.imgProcessor.WritePartial(session.UploadID, offset, reader)
+	if updateErr := server.repo.UpdateUploadSession(session.UploadID, received); updateErr != nil {
+		log.Print(updateErr)
+	}
+	if reader.err != nil {
+		return logError(reader.err)
+	}
+	if err != nil {
+		return logError(toStatus("cannot write upload data", err))
+	}
+
+	res := &pb.UploadResponse{Filename: session.Filename, Received: received}
+	if received == session.Size {
+		newImage, err := server.finishUpload(session)
+		if err != nil {
+			return logError(err)
+		}
+		res.ImageId = newImage.ImageId
+		res.CreatedAt = timestamp(newImage.CreatedAt)
+	}
+
+	err = stream.SendAndClose(res)
+	if err != nil {
+		return logError(toStatus("cannot send response", err))
+	}
+
+	log.Printf("received %d of %d bytes of upload %s", received, session.Size, session.UploadID)
+	return nil
+}
+
+// finishUpload verifies a complete upload session, moves it into the image store and drops the session.
+func (server *serverAPI) finishUpload(session storage.UploadSession) (storage.ImagesInfo, error) {
+	partial, err := server.imgProcessor.OpenPartial(session.UploadID)
+	if err != nil {
+		return storage.ImagesInfo{}, toStatus("cannot read upload data", err)
+	}
+	defer partial.Close()
+
+	newImage := storage.ImagesInfo{
+		Filename:    session.Filename,
+		Tags:        session.Tags,
+		ContentType: session.ContentType,
+		Size:        session.Size,
+		CreatedAt:   time.Now(),
+		Namespace:   session.Namespace,
+	}
+	header := &pb.ImageHeader{Size: session.Size, Sha256: session.Checksum}
+	reader := &verifiedReader{r: partial, header: header, hash: sha256.New()}
+	newImage.ImageId, err = server.imgProcessor.SaveNewImage(reader, newImage, server.repo)
+	if reader.err != nil {
+		server.dropUpload(session.UploadID)
+		return storage.ImagesInfo{}, reader.err
+	}
+	if err != nil {
+		return storage.ImagesInfo{}, toStatus("cannot save image to the store", err)
+	}
+
+	server.dropUpload(session.UploadID)
+	server.warnUsage(newImage.Namespace)
+
+	return newImage, nil
+}
+
+func (server *serverAPI) dropUpload(uploadID string) {
+	if err := server.repo.DeleteUploadSession(uploadID); err != nil {
+		log.Print(err)
+	}
+	if err := server.imgProcessor.RemovePartial(uploadID); err != nil {
+		log.Print(err)
+	}
+}
+
+// verifiedReader passes r through and reports a DataLoss status instead of io.EOF
+// when the data does not match the declared size and checksum.
+type verifiedReader struct {
+	r      io.Reader
+	header *pb.ImageHeader
+	hash   hash.Hash
+	size   int64
+	err    error
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+
+	n, err := v.r.Read(p)
+	v.size += int64(n)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		v.err = verifyContent(v.size, v.hash, v.header)
+		if v.err != nil {
+			return n, v.err
+		}
+	}
+
+	return n, err
+}
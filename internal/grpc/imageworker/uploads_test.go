@@ -0,0 +1,81 @@
+package imageworker_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/Niiazgulov/tages.git/internal/storage/blob"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// resume sends data to the upload session uploadID from offset and returns the response.
+func resume(ctx context.Context, client pb.ImageWorkerClient, uploadID string, offset int64, data string) (*pb.UploadResponse, error) {
+	stream, err := client.UploadImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_Resume{Resume: &pb.ResumeUpload{UploadId: uploadID, Offset: offset}}})
+	if err == nil {
+		err = stream.Send(&pb.UploadRequest{Data: &pb.UploadRequest_ImageData{ImageData: []byte(data)}})
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return stream.CloseAndRecv()
+}
+
+func TestUploadSessionsOwned(t *testing.T) {
+	client := startQuotaServer(t, blob.NewMemory())
+	alice := as("alice", "alice-key")
+
+	header := &pb.ImageHeader{Filename: "a.jpg", Size: 8}
+	started, err := client.StartUpload(alice, &pb.StartUploadRequest{Header: header})
+	if err != nil {
+		t.Fatalf("StartUpload: %v", err)
+	}
+	uploadID := started.GetUploadId()
+	if _, err := resume(alice, client, uploadID, 0, "alic"); err != nil {
+		t.Fatalf("alice cannot send the first half: %v", err)
+	}
+
+	// Knowing the upload id is not enough to look at, add to or finish someone else's upload.
+	for name, ctx := range map[string]context.Context{
+		"anonymous": context.Background(),
+		"bob":       as("bob", "bob-key"),
+		"wrong key": as("alice", "bob-key"),
+	} {
+		_, err := client.GetUploadStatus(ctx, &pb.UploadStatusRequest{UploadId: uploadID})
+		if code := status.Code(err); code != codes.PermissionDenied {
+			t.Errorf("GetUploadStatus by %s ended with %v, want PermissionDenied", name, code)
+		}
+		_, err = resume(ctx, client, uploadID, 4, "evil")
+		if code := status.Code(err); code != codes.PermissionDenied {
+			t.Errorf("resume by %s ended with %v, want PermissionDenied", name, code)
+		}
+	}
+
+	st, err := client.GetUploadStatus(alice, &pb.UploadStatusRequest{UploadId: uploadID})
+	if err != nil {
+		t.Fatalf("GetUploadStatus: %v", err)
+	}
+	if st.GetReceived() != 4 {
+		t.Errorf("upload holds %d bytes, want the 4 alice sent", st.GetReceived())
+	}
+	res, err := resume(alice, client, uploadID, 4, "e-ok")
+	if err != nil || res.GetImageId() == "" {
+		t.Fatalf("alice cannot finish the upload: %v", err)
+	}
+
+	usage, err := client.GetUsage(alice, &pb.GetUsageRequest{})
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	if got := usage.GetUsage().GetBytes(); got != 8 {
+		t.Errorf("alice uses %d bytes, want 8", got)
+	}
+}
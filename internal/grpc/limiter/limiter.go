@@ -0,0 +1,293 @@
+package limiter
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/protos"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Pool names a group of methods that share one concurrency budget.
+type Pool string
+
+const (
+	Transfer Pool = "transfer"
+	Listing  Pool = "listing"
+)
+
+// Classifier reports which pool a full gRPC method name belongs to.
+// Methods outside of any pool are not limited.
+type Classifier func(fullMethod string) (Pool, bool)
+
+// Admission decides what happens to a call that finds its pool full.
+type Admission string
+
+const (
+	// FailFast rejects the call at once with ResourceExhausted.
+	FailFast Admission = "fail_fast"
+	// Wait queues the call in FIFO order for up to MaxWait or until its deadline.
+	Wait Admission = "wait"
+)
+
+// Config holds the budgets of the limiter. A zero or missing budget disables that limit.
+// Admission and MaxWait apply to the server-wide pools; per-client budgets always fail fast.
+// ClientKeys maps client ids to their keys; callers without a matching key are told apart
+// by their address.
+type Config struct {
+	Global      map[Pool]int
+	PerClient   map[Pool]int
+	ClientKeys  map[string]string
+	IdleTimeout time.Duration
+	Admission   Admission
+	MaxWait     time.Duration
+}
+
+// PoolStats is a snapshot of one server-wide pool.
+type PoolStats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+}
+
+// Limiter caps the number of in-flight calls per pool across the whole server
+// and, separately, per calling client.
+type Limiter struct {
+	classify  Classifier
+	pools     map[Pool]*semaphore
+	perClient map[Pool]int
+	keys      map[string]string
+	idle      time.Duration
+	maxWait   time.Duration
+
+	mutex     sync.Mutex
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+type clientBucket struct {
+	inFlight map[Pool]int
+	lastSeen time.Time
+}
+
+func New(classify Classifier, cfg Config) *Limiter {
+	pools := make(map[Pool]*semaphore, len(cfg.Global))
+	for pool, limit := range cfg.Global {
+		if limit > 0 {
+			pools[pool] = newSemaphore(limit)
+		}
+	}
+
+	var maxWait time.Duration
+	if cfg.Admission == Wait {
+		maxWait = cfg.MaxWait
+	}
+
+	return &Limiter{
+		classify:  classify,
+		pools:     pools,
+		perClient: cfg.PerClient,
+		keys:      cfg.ClientKeys,
+		idle:      cfg.IdleTimeout,
+		maxWait:   maxWait,
+		clients:   make(map[string]*clientBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *Limiter) acquire(ctx context.Context, fullMethod string) (func(), error) {
+	pool, ok := l.classify(fullMethod)
+	if !ok {
+		return func() {}, nil
+	}
+
+	releaseClient, err := l.acquireClient(l.clientKey(ctx), pool)
+	if err != nil {
+		return nil, err
+	}
+
+	sem, ok := l.pools[pool]
+	if !ok {
+		return releaseClient, nil
+	}
+
+	err = sem.acquire(ctx, l.maxWait)
+	switch {
+	case err == nil:
+		return func() {
+			sem.release()
+			releaseClient()
+		}, nil
+	case errors.Is(err, context.DeadlineExceeded):
+		releaseClient()
+		return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded while queued for %s pool", pool)
+	case errors.Is(err, context.Canceled):
+		releaseClient()
+		return nil, status.Errorf(codes.Canceled, "canceled while queued for %s pool", pool)
+	default:
+		releaseClient()
+		stats := sem.stats()
+		return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent %s requests (limit %d, queued %d)", pool, stats.Limit, stats.Queued)
+	}
+}
+
+func (l *Limiter) acquireClient(key string, pool Pool) (func(), error) {
+	limit := l.perClient[pool]
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	bucket, ok := l.clients[key]
+	if !ok {
+		bucket = &clientBucket{inFlight: make(map[Pool]int)}
+		l.clients[key] = bucket
+	}
+	bucket.lastSeen = now
+
+	if bucket.inFlight[pool] >= limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent %s requests from client %s (limit %d)", pool, key, limit)
+	}
+	bucket.inFlight[pool]++
+
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		bucket.inFlight[pool]--
+		bucket.lastSeen = time.Now()
+	}, nil
+}
+
+// sweep drops buckets of clients that have nothing in flight and were not seen
+// for the idle timeout. It runs at most once per timeout and must be called with the mutex held.
+func (l *Limiter) sweep(now time.Time) {
+	if l.idle <= 0 || now.Sub(l.lastSweep) < l.idle {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.clients {
+		if now.Sub(bucket.lastSeen) < l.idle {
+			continue
+		}
+		busy := false
+		for _, n := range bucket.inFlight {
+			if n > 0 {
+				busy = true
+				break
+			}
+		}
+		if !busy {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// clientKey identifies the caller by its proven client id or, failing that, by its peer host,
+// so a client cannot get a fresh bucket by sending another id.
+func (l *Limiter) clientKey(ctx context.Context) string {
+	if id := ClientID(ctx, l.keys); id != "" {
+		return "id:" + id
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return "addr:" + addr
+}
+
+// ClientID returns the client id the caller sent along with the key keys holds for it, or ""
+// if it sent no id or not the matching key.
+func ClientID(ctx context.Context, keys map[string]string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	id, key := firstValue(md, protos.ClientIDKey), firstValue(md, protos.ClientKeyKey)
+
+	want, known := keys[id]
+	if id == "" || !known || want == "" || subtle.ConstantTimeCompare([]byte(key), []byte(want)) != 1 {
+		return ""
+	}
+
+	return id
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// InFlight returns the number of calls currently holding a slot in the pool.
+func (l *Limiter) InFlight(pool Pool) int {
+	return l.Stats()[pool].InFlight
+}
+
+// QueueDepth returns the number of calls waiting for a slot in the pool.
+func (l *Limiter) QueueDepth(pool Pool) int {
+	return l.Stats()[pool].Queued
+}
+
+// Stats returns a snapshot of every server-wide pool.
+func (l *Limiter) Stats() map[Pool]PoolStats {
+	stats := make(map[Pool]PoolStats, len(l.pools))
+	for pool, sem := range l.pools {
+		stats[pool] = sem.stats()
+	}
+
+	return stats
+}
+
+// Clients returns the number of client buckets currently tracked.
+func (l *Limiter) Clients() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.clients)
+}
+
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := l.acquire(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		return handler(ctx, req)
+	}
+}
+
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.acquire(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return handler(srv, ss)
+	}
+}
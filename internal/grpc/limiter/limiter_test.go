@@ -0,0 +1,440 @@
+package limiter_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/grpc/limiter"
+	"github.com/Niiazgulov/tages.git/protos"
+	pb "github.com/Niiazgulov/tages.git/protos/gen/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// blockingServer holds every transfer until release is closed and records how many it held at once
+// and the order the downloads were admitted in.
+type blockingServer struct {
+	pb.UnimplementedImageWorkerServer
+
+	release chan struct{}
+
+	mutex    sync.Mutex
+	inFlight int
+	peak     int
+	admitted []string
+}
+
+func (s *blockingServer) hold(ctx context.Context) error {
+	s.mutex.Lock()
+	s.inFlight++
+	if s.inFlight > s.peak {
+		s.peak = s.inFlight
+	}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		s.inFlight--
+		s.mutex.Unlock()
+	}()
+
+	select {
+	case <-s.release:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (s *blockingServer) StreamImage(req *pb.DownloadRequest, stream pb.ImageWorker_StreamImageServer) error {
+	s.mutex.Lock()
+	s.admitted = append(s.admitted, req.GetFilename())
+	s.mutex.Unlock()
+
+	if err := s.hold(stream.Context()); err != nil {
+		return err
+	}
+
+	header := &pb.ImageHeader{Filename: req.GetFilename()}
+	return stream.Send(&pb.DownloadChunk{Data: &pb.DownloadChunk_Header{Header: header}})
+}
+
+func (s *blockingServer) UploadImage(stream pb.ImageWorker_UploadImageServer) error {
+	if err := s.hold(stream.Context()); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&pb.UploadResponse{})
+}
+
+func (s *blockingServer) Peak() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.peak
+}
+
+func (s *blockingServer) Admitted() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]string(nil), s.admitted...)
+}
+
+func classify(fullMethod string) (limiter.Pool, bool) {
+	switch fullMethod {
+	case pb.ImageWorker_StreamImage_FullMethodName, pb.ImageWorker_UploadImage_FullMethodName:
+		return limiter.Transfer, true
+	case pb.ImageWorker_ListImages_FullMethodName:
+		return limiter.Listing, true
+	default:
+		return "", false
+	}
+}
+
+// startServer serves a blockingServer behind a limiter built from cfg over an in-memory connection.
+func startServer(t *testing.T, cfg limiter.Config) (pb.ImageWorkerClient, *blockingServer, *limiter.Limiter) {
+	t.Helper()
+
+	lim := limiter.New(classify, cfg)
+	fake := &blockingServer{release: make(chan struct{})}
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(lim.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(lim.StreamServerInterceptor()),
+	)
+	pb.RegisterImageWorkerServer(server, fake)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("cannot dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewImageWorkerClient(conn), fake, lim
+}
+
+// transfer runs a StreamImage when n is even and an UploadImage when it is odd and returns
+// the status code the call ended with.
+func transfer(ctx context.Context, client pb.ImageWorkerClient, n int) codes.Code {
+	if n%2 == 0 {
+		stream, err := client.StreamImage(ctx, &pb.DownloadRequest{Filename: "a.jpg"})
+		if err != nil {
+			return status.Code(err)
+		}
+		_, err = stream.Recv()
+		return status.Code(err)
+	}
+
+	stream, err := client.UploadImage(ctx)
+	if err != nil {
+		return status.Code(err)
+	}
+	_, err = stream.CloseAndRecv()
+	return status.Code(err)
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGlobalCap(t *testing.T) {
+	const (
+		limit = 5
+		calls = 50
+	)
+	client, fake, lim := startServer(t, limiter.Config{Global: map[limiter.Pool]int{limiter.Transfer: limit}})
+
+	results := make(chan codes.Code, calls)
+	for n := 0; n < calls; n++ {
+		go func(n int) { results <- transfer(context.Background(), client, n) }(n)
+	}
+
+	// Calls past the cap fail fast while the admitted ones are still held.
+	for n := 0; n < calls-limit; n++ {
+		if code := <-results; code != codes.ResourceExhausted {
+			t.Errorf("call over the cap ended with %v, want ResourceExhausted", code)
+		}
+	}
+	waitFor(t, "the admitted calls", func() bool { return lim.InFlight(limiter.Transfer) == limit })
+
+	close(fake.release)
+	for n := 0; n < limit; n++ {
+		if code := <-results; code != codes.OK {
+			t.Errorf("admitted call ended with %v, want OK", code)
+		}
+	}
+
+	if peak := fake.Peak(); peak > limit {
+		t.Errorf("%d transfers ran at once, want at most %d", peak, limit)
+	}
+	if n := lim.InFlight(limiter.Transfer); n != 0 {
+		t.Errorf("%d transfers still hold a slot after finishing", n)
+	}
+}
+
+func TestUnlimitedMethods(t *testing.T) {
+	client, _, _ := startServer(t, limiter.Config{Global: map[limiter.Pool]int{limiter.Transfer: 1, limiter.Listing: 1}})
+
+	// StatImage is in no pool, so the limiter passes it through to the unimplemented handler.
+	for n := 0; n < 3; n++ {
+		_, err := client.StatImage(context.Background(), &pb.StatImageRequest{})
+		if code := status.Code(err); code != codes.Unimplemented {
+			t.Errorf("StatImage ended with %v, want Unimplemented", code)
+		}
+	}
+}
+
+// clientKeys holds the keys of the clients the tests identify with asClient.
+var clientKeys = map[string]string{"alice": "alice-key", "bob": "bob-key", "carol": "carol-key", "dave": "dave-key"}
+
+// asClient identifies calls made with ctx as coming from client id, proven by its key.
+func asClient(ctx context.Context, id string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, protos.ClientIDKey, id, protos.ClientKeyKey, clientKeys[id])
+}
+
+func TestPerClientCap(t *testing.T) {
+	const (
+		limit = 2
+		calls = 5
+	)
+	client, fake, lim := startServer(t, limiter.Config{
+		PerClient:  map[limiter.Pool]int{limiter.Transfer: limit},
+		ClientKeys: clientKeys,
+	})
+
+	results := make(chan codes.Code, 2*calls)
+	for _, id := range []string{"alice", "bob"} {
+		ctx := asClient(context.Background(), id)
+		for n := 0; n < calls; n++ {
+			go func(n int) { results <- transfer(ctx, client, n) }(n)
+		}
+	}
+
+	// Each client is held to its own budget, so one client's calls do not use up the other's.
+	for n := 0; n < 2*(calls-limit); n++ {
+		if code := <-results; code != codes.ResourceExhausted {
+			t.Errorf("call over the client cap ended with %v, want ResourceExhausted", code)
+		}
+	}
+	if n := lim.Clients(); n != 2 {
+		t.Errorf("limiter tracks %d clients, want 2", n)
+	}
+	waitFor(t, "the admitted calls", func() bool { return fake.Peak() == 2*limit })
+
+	close(fake.release)
+	for n := 0; n < 2*limit; n++ {
+		if code := <-results; code != codes.OK {
+			t.Errorf("admitted call ended with %v, want OK", code)
+		}
+	}
+}
+
+func TestIdleClientsEvicted(t *testing.T) {
+	const idle = 50 * time.Millisecond
+	client, fake, lim := startServer(t, limiter.Config{
+		PerClient:   map[limiter.Pool]int{limiter.Transfer: 1},
+		ClientKeys:  clientKeys,
+		IdleTimeout: idle,
+	})
+	close(fake.release)
+
+	for _, id := range []string{"alice", "bob", "carol"} {
+		if code := transfer(asClient(context.Background(), id), client, 0); code != codes.OK {
+			t.Fatalf("transfer of %s ended with %v", id, code)
+		}
+	}
+	if n := lim.Clients(); n != 3 {
+		t.Fatalf("limiter tracks %d clients, want 3", n)
+	}
+
+	// The next call sweeps the buckets of everyone idle for longer than the timeout.
+	time.Sleep(2 * idle)
+	if code := transfer(asClient(context.Background(), "dave"), client, 0); code != codes.OK {
+		t.Fatalf("transfer of dave ended with %v", code)
+	}
+	if n := lim.Clients(); n != 1 {
+		t.Errorf("limiter tracks %d clients after the idle ones were swept, want 1", n)
+	}
+}
+
+func TestClientsFallBackToAddress(t *testing.T) {
+	client, fake, _ := startServer(t, limiter.Config{PerClient: map[limiter.Pool]int{limiter.Transfer: 1}})
+
+	// Calls without a client id share the bucket of their peer address.
+	done := make(chan codes.Code, 1)
+	go func() { done <- transfer(context.Background(), client, 0) }()
+	waitFor(t, "the first call", func() bool { return fake.Peak() == 1 })
+
+	if code := transfer(context.Background(), client, 1); code != codes.ResourceExhausted {
+		t.Errorf("second anonymous call ended with %v, want ResourceExhausted", code)
+	}
+	close(fake.release)
+	if code := <-done; code != codes.OK {
+		t.Errorf("first anonymous call ended with %v, want OK", code)
+	}
+}
+
+func TestUnprovenClientIDs(t *testing.T) {
+	client, fake, lim := startServer(t, limiter.Config{
+		PerClient:  map[limiter.Pool]int{limiter.Transfer: 1},
+		ClientKeys: clientKeys,
+	})
+
+	done := make(chan codes.Code, 1)
+	go func() {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), protos.ClientIDKey, "id-0")
+		done <- transfer(ctx, client, 0)
+	}()
+	waitFor(t, "the first call", func() bool { return fake.Peak() == 1 })
+
+	// Without the key, or with a wrong one, a new id on every call does not get a new bucket.
+	for n := 1; n <= 5; n++ {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), protos.ClientIDKey, fmt.Sprintf("id-%d", n))
+		if n%2 == 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, protos.ClientKeyKey, "guessed")
+		}
+		if code := transfer(ctx, client, n); code != codes.ResourceExhausted {
+			t.Errorf("call with unproven id-%d ended with %v, want ResourceExhausted", n, code)
+		}
+	}
+	// Neither does a known id sent with another client's key.
+	ctx := metadata.AppendToOutgoingContext(context.Background(), protos.ClientIDKey, "alice", protos.ClientKeyKey, clientKeys["bob"])
+	if code := transfer(ctx, client, 0); code != codes.ResourceExhausted {
+		t.Errorf("call with alice's id and bob's key ended with %v, want ResourceExhausted", code)
+	}
+	if n := lim.Clients(); n != 1 {
+		t.Errorf("limiter tracks %d clients, want the single bucket of the address", n)
+	}
+
+	close(fake.release)
+	if code := <-done; code != codes.OK {
+		t.Errorf("first call ended with %v, want OK", code)
+	}
+}
+
+func TestWaitAdmission(t *testing.T) {
+	client, fake, lim := startServer(t, limiter.Config{
+		Global:    map[limiter.Pool]int{limiter.Transfer: 1},
+		Admission: limiter.Wait,
+		MaxWait:   time.Minute,
+	})
+
+	first := make(chan codes.Code, 1)
+	go func() { first <- transfer(context.Background(), client, 0) }()
+	waitFor(t, "the first call", func() bool { return fake.Peak() == 1 })
+
+	// A queued call gives up when its own deadline passes before a slot frees up.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if code := transfer(ctx, client, 1); code != codes.DeadlineExceeded {
+		t.Errorf("call queued past its deadline ended with %v, want DeadlineExceeded", code)
+	}
+	// The client gives up on its own deadline, the server drops the waiter soon after.
+	waitFor(t, "the expired call to leave the queue", func() bool { return lim.QueueDepth(limiter.Transfer) == 0 })
+
+	// A queued call without a deadline is admitted once the slot is released.
+	second := make(chan codes.Code, 1)
+	go func() { second <- transfer(context.Background(), client, 1) }()
+	waitFor(t, "the second call to queue", func() bool { return lim.QueueDepth(limiter.Transfer) == 1 })
+
+	close(fake.release)
+	if code := <-first; code != codes.OK {
+		t.Errorf("first call ended with %v, want OK", code)
+	}
+	if code := <-second; code != codes.OK {
+		t.Errorf("queued call ended with %v, want OK", code)
+	}
+	if peak := fake.Peak(); peak != 1 {
+		t.Errorf("%d transfers ran at once, want 1", peak)
+	}
+}
+
+func TestWaitAdmissionOrder(t *testing.T) {
+	const queued = 5
+	client, fake, lim := startServer(t, limiter.Config{
+		Global:    map[limiter.Pool]int{limiter.Transfer: 1},
+		Admission: limiter.Wait,
+		MaxWait:   time.Minute,
+	})
+
+	download := func(filename string, results chan<- codes.Code) {
+		stream, err := client.StreamImage(context.Background(), &pb.DownloadRequest{Filename: filename})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		results <- status.Code(err)
+	}
+
+	results := make(chan codes.Code, queued+1)
+	go download("first", results)
+	waitFor(t, "the first call", func() bool { return fake.Peak() == 1 })
+
+	// Each caller joins the queue only after the one before it, so the arrival order is known.
+	want := []string{"first"}
+	for n := 1; n <= queued; n++ {
+		filename := fmt.Sprintf("queued-%d", n)
+		want = append(want, filename)
+		go download(filename, results)
+		waitFor(t, filename+" to queue", func() bool { return lim.QueueDepth(limiter.Transfer) == n })
+	}
+
+	close(fake.release)
+	for n := 0; n <= queued; n++ {
+		if code := <-results; code != codes.OK {
+			t.Errorf("call ended with %v, want OK", code)
+		}
+	}
+
+	if got := fake.Admitted(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("calls were admitted in order %v, want %v", got, want)
+	}
+}
+
+func TestWaitAdmissionMaxWait(t *testing.T) {
+	client, fake, lim := startServer(t, limiter.Config{
+		Global:    map[limiter.Pool]int{limiter.Transfer: 1},
+		Admission: limiter.Wait,
+		MaxWait:   20 * time.Millisecond,
+	})
+
+	first := make(chan codes.Code, 1)
+	go func() { first <- transfer(context.Background(), client, 0) }()
+	waitFor(t, "the first call", func() bool { return fake.Peak() == 1 })
+
+	// Waiting is bounded by MaxWait even for calls without a deadline.
+	if code := transfer(context.Background(), client, 1); code != codes.ResourceExhausted {
+		t.Errorf("call queued past MaxWait ended with %v, want ResourceExhausted", code)
+	}
+	if n := lim.QueueDepth(limiter.Transfer); n != 0 {
+		t.Errorf("%d calls still queued after MaxWait", n)
+	}
+
+	close(fake.release)
+	if code := <-first; code != codes.OK {
+		t.Errorf("first call ended with %v, want OK", code)
+	}
+}
@@ -0,0 +1,92 @@
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errPoolFull = errors.New("pool is full")
+
+// semaphore is a counting semaphore that admits waiters strictly in arrival order.
+type semaphore struct {
+	mutex   sync.Mutex
+	limit   int
+	held    int
+	waiters list.List
+}
+
+func newSemaphore(limit int) *semaphore {
+	return &semaphore{limit: limit}
+}
+
+// acquire takes a slot, waiting at most maxWait (and no longer than ctx allows) when none is free.
+// A non-positive maxWait fails immediately on a full pool.
+func (s *semaphore) acquire(ctx context.Context, maxWait time.Duration) error {
+	s.mutex.Lock()
+	if s.held < s.limit && s.waiters.Len() == 0 {
+		s.held++
+		s.mutex.Unlock()
+		return nil
+	}
+	if maxWait <= 0 {
+		s.mutex.Unlock()
+		return errPoolFull
+	}
+	ready := make(chan struct{})
+	elem := s.waiters.PushBack(ready)
+	s.mutex.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = errPoolFull
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-ready:
+		// The slot was handed over while we were giving up: pass it on.
+		s.held--
+		s.notify()
+	default:
+		s.waiters.Remove(elem)
+	}
+
+	return err
+}
+
+func (s *semaphore) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.held--
+	s.notify()
+}
+
+// notify hands free slots to the waiters at the front of the queue. It must be called with the mutex held.
+func (s *semaphore) notify() {
+	for s.held < s.limit && s.waiters.Len() > 0 {
+		front := s.waiters.Front()
+		s.waiters.Remove(front)
+		s.held++
+		close(front.Value.(chan struct{}))
+	}
+}
+
+func (s *semaphore) stats() PoolStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return PoolStats{Limit: s.limit, InFlight: s.held, Queued: s.waiters.Len()}
+}
@@ -0,0 +1,83 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("blob not found")
+	ErrRange    = errors.New("requested byte range is not satisfiable")
+)
+
+// Info describes a stored blob.
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store is a flat namespace of blobs named by slash separated keys. Put must be atomic:
+// readers see either the previous content of a name or the complete new one, and a reader
+// that fails leaves the name untouched.
+type Store interface {
+	// Put stores everything read from r under name, replacing what was there.
+	Put(name string, r io.Reader) (Info, error)
+	// Get opens length bytes of a blob starting at offset; a zero length reads up to the
+	// end. The caller must close the returned reader.
+	Get(name string, offset, length int64) (io.ReadCloser, Info, error)
+	Stat(name string) (Info, error)
+	// Copy stores the content of src under dst, replacing what was there.
+	Copy(src, dst string) error
+	// Delete removes a blob. Deleting a missing blob is not an error.
+	Delete(name string) error
+	// List calls fn for every blob whose name starts with prefix, stopping at the first error.
+	List(prefix string, fn func(Info) error) error
+}
+
+// Sized tells Put that r yields exactly size bytes. Stores that need the size up front, like
+// S3, can then upload r without buffering it; a reader ending early or late fails the Put.
+func Sized(r io.Reader, size int64) io.Reader {
+	return sizedReader{Reader: r, size: size}
+}
+
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+// readerSize returns how many bytes are left in r, or -1 if that is not known.
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case sizedReader:
+		return r.size
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	default:
+		return -1
+	}
+}
+
+// checkRange validates a range against a blob of size bytes and returns the length to read.
+func checkRange(offset, length, size int64) (int64, error) {
+	if offset < 0 || length < 0 || offset > size {
+		return 0, ErrRange
+	}
+	if length == 0 || offset+length > size {
+		length = size - offset
+	}
+
+	return length, nil
+}
@@ -0,0 +1,259 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// diskTempPrefix names the files Disk writes before moving them into place.
+const diskTempPrefix = ".upload-"
+
+// Disk keeps blobs as files under a folder; slashes in names become subfolders.
+type Disk struct {
+	folder string
+}
+
+func NewDisk(folder string) *Disk {
+	return &Disk{folder: folder}
+}
+
+func (d *Disk) path(name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("blob name %q leaves the storage folder", name)
+	}
+
+	return filepath.Join(d.folder, filepath.FromSlash(name)), nil
+}
+
+// Put writes r into a temporary file next to the target, flushes it and renames it over
+// the target, so a crash never leaves a truncated blob behind.
+func (d *Disk) Put(name string, r io.Reader) (Info, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return Info{}, err
+	}
+	dir := filepath.Dir(path)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return Info{}, fmt.Errorf("cannot create blob folder: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(dir, diskTempPrefix+"*")
+	if err != nil {
+		return Info{}, fmt.Errorf("cannot create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = io.Copy(tmpFile, r)
+	if err != nil {
+		tmpFile.Close()
+		return Info{}, fmt.Errorf("cannot write blob: %w", err)
+	}
+	err = closeSynced(tmpFile)
+	if err != nil {
+		return Info{}, err
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return Info{}, fmt.Errorf("cannot move blob into place: %w", err)
+	}
+	err = syncDir(dir)
+	if err != nil {
+		return Info{}, err
+	}
+
+	return d.Stat(name)
+}
+
+func (d *Disk) Get(name string, offset, length int64) (io.ReadCloser, Info, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, Info{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("cannot open blob: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, Info{}, fmt.Errorf("cannot stat blob: %w", err)
+	}
+	info := Info{Name: name, Size: stat.Size(), ModTime: stat.ModTime()}
+
+	n, err := checkRange(offset, length, info.Size)
+	if err != nil {
+		file.Close()
+		return nil, Info{}, fmt.Errorf("offset %d, length %d, size %d: %w", offset, length, info.Size, err)
+	}
+
+	return sectionReader{Reader: io.NewSectionReader(file, offset, n), Closer: file}, info, nil
+}
+
+type sectionReader struct {
+	io.Reader
+	io.Closer
+}
+
+func (d *Disk) Stat(name string) (Info, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return Info{}, err
+	}
+
+	stat, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("cannot stat blob: %w", err)
+	}
+
+	return Info{Name: name, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+// Copy hard links dst to src when it can, so copies cost no space until one of them is
+// replaced; blobs are never changed in place. It falls back to copying the content.
+func (d *Disk) Copy(src, dst string) error {
+	srcPath, err := d.path(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := d.path(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(srcPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s: %w", src, ErrNotFound)
+	}
+
+	dir := filepath.Dir(dstPath)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("cannot create blob folder: %w", err)
+	}
+
+	link := filepath.Join(dir, diskTempPrefix+uuid.NewString())
+	err = os.Link(srcPath, link)
+	if err == nil {
+		err = os.Rename(link, dstPath)
+		if err != nil {
+			os.Remove(link)
+			return fmt.Errorf("cannot move blob copy into place: %w", err)
+		}
+		return syncDir(dir)
+	}
+
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("cannot open blob: %w", err)
+	}
+	defer file.Close()
+
+	_, err = d.Put(dst, file)
+	return err
+}
+
+func (d *Disk) Delete(name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot delete blob: %w", err)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// List walks the folder the prefix points into and reports the files whose slash separated
+// path starts with prefix. Temporary files of unfinished writes are listed too, so they can
+// be cleaned up.
+func (d *Disk) List(prefix string, fn func(Info) error) error {
+	root := d.folder
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		var err error
+		root, err = d.path(prefix[:i])
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(root); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.folder, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return fn(Info{Name: name, Size: stat.Size(), ModTime: stat.ModTime()})
+	})
+}
+
+// syncDir flushes the entries of dir, so renames and removals inside it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("cannot open folder to sync: %w", err)
+	}
+	defer d.Close()
+
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("cannot sync folder: %w", err)
+	}
+
+	return nil
+}
+
+// closeSynced flushes file to stable storage and closes it.
+func closeSynced(file *os.File) error {
+	err := file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot flush temp file: %w", err)
+	}
+
+	return nil
+}
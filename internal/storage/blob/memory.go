@@ -0,0 +1,119 @@
+package blob
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps blobs in memory. It suits tests and throwaway servers; nothing survives a restart.
+type Memory struct {
+	mutex sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string]memoryBlob)}
+}
+
+func (m *Memory) Put(name string, r io.Reader) (Info, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Info{}, fmt.Errorf("cannot read blob: %w", err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	blob := memoryBlob{data: data, modTime: time.Now()}
+	m.blobs[name] = blob
+
+	return blob.info(name), nil
+}
+
+// Get serves the stored slice directly; it is safe because blobs are replaced, never modified.
+func (m *Memory) Get(name string, offset, length int64) (io.ReadCloser, Info, error) {
+	m.mutex.RLock()
+	blob, ok := m.blobs[name]
+	m.mutex.RUnlock()
+	if !ok {
+		return nil, Info{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	size := int64(len(blob.data))
+	n, err := checkRange(offset, length, size)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("offset %d, length %d, size %d: %w", offset, length, size, err)
+	}
+
+	return io.NopCloser(bytes.NewReader(blob.data[offset : offset+n])), blob.info(name), nil
+}
+
+func (m *Memory) Stat(name string) (Info, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	blob, ok := m.blobs[name]
+	if !ok {
+		return Info{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	return blob.info(name), nil
+}
+
+func (m *Memory) Copy(src, dst string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	blob, ok := m.blobs[src]
+	if !ok {
+		return fmt.Errorf("%s: %w", src, ErrNotFound)
+	}
+	blob.modTime = time.Now()
+	m.blobs[dst] = blob
+
+	return nil
+}
+
+func (m *Memory) Delete(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.blobs, name)
+
+	return nil
+}
+
+// List reports the blobs in name order from a snapshot taken when it is called.
+func (m *Memory) List(prefix string, fn func(Info) error) error {
+	m.mutex.RLock()
+	infos := make([]Info, 0, len(m.blobs))
+	for name, blob := range m.blobs {
+		if strings.HasPrefix(name, prefix) {
+			infos = append(infos, blob.info(name))
+		}
+	}
+	m.mutex.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b memoryBlob) info(name string) Info {
+	return Info{Name: name, Size: int64(len(b.data)), ModTime: b.modTime}
+}
@@ -0,0 +1,163 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config points S3 at a bucket of an S3 compatible service such as AWS or MinIO.
+// Prefix, if set, is put in front of every object name.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// S3 keeps blobs as objects of a bucket. Objects only appear once fully uploaded, which
+// gives Put the atomicity the Store contract asks for.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 connects to the service and creates the bucket if it does not exist yet.
+func NewS3(cfg S3Config) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create S3 client: %w", err)
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check S3 bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create S3 bucket %s: %w", cfg.Bucket, err)
+		}
+	}
+
+	return &S3{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *S3) key(name string) string {
+	return s.prefix + name
+}
+
+// s3PartSize is the size of the parts of multipart uploads, the smallest S3 accepts. Each
+// part is buffered in memory while it is sent, and 10000 of them still hold 48 GiB.
+const s3PartSize = 5 << 20
+
+// Put streams r to the bucket, in a single request if its size is known and fits into one
+// part and as a multipart upload otherwise; a failing reader aborts the upload.
+func (s *S3) Put(name string, r io.Reader) (Info, error) {
+	opts := minio.PutObjectOptions{PartSize: s3PartSize}
+	uploaded, err := s.client.PutObject(context.Background(), s.bucket, s.key(name), r, readerSize(r), opts)
+	if err != nil {
+		return Info{}, s.objectError(name, "cannot put object", err)
+	}
+
+	return Info{Name: name, Size: uploaded.Size, ModTime: uploaded.LastModified}, nil
+}
+
+func (s *S3) Get(name string, offset, length int64) (io.ReadCloser, Info, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+
+	n, err := checkRange(offset, length, info.Size)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("offset %d, length %d, size %d: %w", offset, length, info.Size, err)
+	}
+	if n == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), info, nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	err = opts.SetRange(offset, offset+n-1)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("cannot set object range: %w", err)
+	}
+	object, err := s.client.GetObject(context.Background(), s.bucket, s.key(name), opts)
+	if err != nil {
+		return nil, Info{}, s.objectError(name, "cannot get object", err)
+	}
+
+	return object, info, nil
+}
+
+func (s *S3) Stat(name string) (Info, error) {
+	object, err := s.client.StatObject(context.Background(), s.bucket, s.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, s.objectError(name, "cannot stat object", err)
+	}
+
+	return Info{Name: name, Size: object.Size, ModTime: object.LastModified}, nil
+}
+
+// Copy copies the object on the server side, without moving its data through this process.
+func (s *S3) Copy(src, dst string) error {
+	_, err := s.client.CopyObject(context.Background(),
+		minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(dst)},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(src)})
+	if err != nil {
+		return s.objectError(src, "cannot copy object", err)
+	}
+
+	return nil
+}
+
+func (s *S3) Delete(name string) error {
+	err := s.client.RemoveObject(context.Background(), s.bucket, s.key(name), minio.RemoveObjectOptions{})
+	if err != nil {
+		return s.objectError(name, "cannot delete object", err)
+	}
+
+	return nil
+}
+
+func (s *S3) List(prefix string, fn func(Info) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	objects := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.key(prefix), Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return fmt.Errorf("cannot list objects: %w", object.Err)
+		}
+		err := fn(Info{Name: strings.TrimPrefix(object.Key, s.prefix), Size: object.Size, ModTime: object.LastModified})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// objectError turns a missing object into ErrNotFound and wraps anything else.
+func (s *S3) objectError(name, msg string, err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	default:
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+}
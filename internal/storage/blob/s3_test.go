@@ -0,0 +1,99 @@
+package blob
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// S3 tests run against the S3 compatible service these variables point at, e.g. a MinIO
+// started with "docker run -p 9000:9000 minio/minio server /data". They are skipped without
+// an endpoint.
+const (
+	s3EndpointEnv  = "TAGES_TEST_S3_ENDPOINT"
+	s3AccessKeyEnv = "TAGES_TEST_S3_ACCESS_KEY"
+	s3SecretKeyEnv = "TAGES_TEST_S3_SECRET_KEY"
+	s3BucketEnv    = "TAGES_TEST_S3_BUCKET"
+)
+
+// openS3 returns an S3 keeping its objects under a prefix of its own, removed when t ends.
+func openS3(t *testing.T) Store {
+	t.Helper()
+
+	endpoint := os.Getenv(s3EndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", s3EndpointEnv)
+	}
+	bucket := os.Getenv(s3BucketEnv)
+	if bucket == "" {
+		bucket = "tages-test"
+	}
+
+	store, err := NewS3(S3Config{
+		Endpoint:  endpoint,
+		Bucket:    bucket,
+		Prefix:    "test-" + uuid.NewString() + "/",
+		AccessKey: os.Getenv(s3AccessKeyEnv),
+		SecretKey: os.Getenv(s3SecretKeyEnv),
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	t.Cleanup(func() {
+		var names []string
+		store.List("", func(info Info) error {
+			names = append(names, info.Name)
+			return nil
+		})
+		for _, name := range names {
+			store.Delete(name)
+		}
+	})
+
+	return store
+}
+
+func TestS3(t *testing.T) {
+	testStore(t, openS3)
+}
+
+func TestS3MultipartPut(t *testing.T) {
+	store := openS3(t)
+
+	// Larger than a part, so both uploads are split; the unsized one is read part by part.
+	data := make([]byte, 2*s3PartSize+12345)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for name, r := range map[string]io.Reader{
+		"sized":   Sized(io.MultiReader(bytes.NewReader(data)), int64(len(data))),
+		"unsized": io.MultiReader(bytes.NewReader(data)),
+	} {
+		info, err := store.Put(name, r)
+		if err != nil {
+			t.Fatalf("Put(%s): %v", name, err)
+		}
+		if info.Size != int64(len(data)) {
+			t.Errorf("Put(%s) stored %d bytes, want %d", name, info.Size, len(data))
+		}
+
+		reader, _, err := store.Get(name, s3PartSize-10, 20)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", name, err)
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(got, data[s3PartSize-10:s3PartSize+10]) {
+			t.Errorf("bytes around the first part boundary of %s differ: %v", name, err)
+		}
+	}
+
+	// A sized reader that ends early fails the upload instead of storing a short object.
+	_, err := store.Put("short", Sized(io.MultiReader(bytes.NewReader(data[:100])), 200))
+	if err == nil {
+		t.Error("Put of a reader shorter than its size succeeded")
+	}
+}
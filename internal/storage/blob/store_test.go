@@ -0,0 +1,285 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testStore checks the Store contract, each part on a store of its own from open.
+func testStore(t *testing.T, open func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, Store)
+	}{
+		{"PutAndGet", testPutAndGet},
+		{"Ranges", testRanges},
+		{"Missing", testMissing},
+		{"Replace", testReplace},
+		{"FailedPut", testFailedPut},
+		{"Copy", testCopy},
+		{"List", testList},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+}
+
+func TestDisk(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return NewDisk(t.TempDir()) })
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return NewMemory() })
+}
+
+// mustPut stores data under name.
+func mustPut(t *testing.T, store Store, name, data string) {
+	t.Helper()
+
+	info, err := store.Put(name, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Put(%s): %v", name, err)
+	}
+	if info.Name != name || info.Size != int64(len(data)) {
+		t.Errorf("Put(%s) = %+v, want %d bytes", name, info, len(data))
+	}
+}
+
+// read returns length bytes of name from offset.
+func read(t *testing.T, store Store, name string, offset, length int64) (string, error) {
+	t.Helper()
+
+	r, _, err := store.Get(name, offset, length)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	return string(data), err
+}
+
+// wantBlob fails t unless name holds data.
+func wantBlob(t *testing.T, store Store, name, data string) {
+	t.Helper()
+
+	got, err := read(t, store, name, 0, 0)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", name, err)
+	}
+	if got != data {
+		t.Errorf("%s holds %q, want %q", name, got, data)
+	}
+}
+
+func testPutAndGet(t *testing.T, store Store) {
+	mustPut(t, store, "content/ab/abcdef", "image data")
+	wantBlob(t, store, "content/ab/abcdef", "image data")
+
+	info, err := store.Stat("content/ab/abcdef")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Name != "content/ab/abcdef" || info.Size != 10 || info.ModTime.IsZero() {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	mustPut(t, store, "empty", "")
+	wantBlob(t, store, "empty", "")
+}
+
+func testRanges(t *testing.T, store Store) {
+	mustPut(t, store, "digits", "0123456789")
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 0, "0123456789"},
+		{2, 3, "234"},
+		{7, 0, "789"},
+		{7, 100, "789"},
+		{10, 0, ""},
+	}
+	for _, tt := range tests {
+		got, err := read(t, store, "digits", tt.offset, tt.length)
+		if err != nil || got != tt.want {
+			t.Errorf("Get(%d, %d) = %q, %v, want %q", tt.offset, tt.length, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range [][2]int64{{11, 0}, {-1, 0}, {0, -1}} {
+		_, err := read(t, store, "digits", bad[0], bad[1])
+		if !errors.Is(err, ErrRange) {
+			t.Errorf("Get(%d, %d) failed with %v, want ErrRange", bad[0], bad[1], err)
+		}
+	}
+}
+
+func testMissing(t *testing.T, store Store) {
+	if _, _, err := store.Get("missing", 0, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get failed with %v, want ErrNotFound", err)
+	}
+	if _, err := store.Stat("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat failed with %v, want ErrNotFound", err)
+	}
+	if err := store.Copy("missing", "other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Copy failed with %v, want ErrNotFound", err)
+	}
+	if err := store.Delete("missing"); err != nil {
+		t.Errorf("Delete of a missing blob failed with %v", err)
+	}
+
+	mustPut(t, store, "gone", "soon")
+	if err := store.Delete("gone"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Stat("gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat of a deleted blob failed with %v, want ErrNotFound", err)
+	}
+}
+
+func testReplace(t *testing.T, store Store) {
+	mustPut(t, store, "a", "first version")
+	mustPut(t, store, "a", "second")
+	wantBlob(t, store, "a", "second")
+}
+
+// failingReader yields data and then fails.
+type failingReader struct {
+	data io.Reader
+}
+
+var errBroken = errors.New("connection broken")
+
+func (r failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errBroken
+	}
+	return n, err
+}
+
+func testFailedPut(t *testing.T, store Store) {
+	mustPut(t, store, "a", "complete")
+
+	_, err := store.Put("a", failingReader{data: strings.NewReader("truncated")})
+	if err == nil {
+		t.Fatal("Put from a failing reader succeeded")
+	}
+	wantBlob(t, store, "a", "complete")
+
+	_, err = store.Put("b", failingReader{data: strings.NewReader("truncated")})
+	if err == nil {
+		t.Fatal("Put from a failing reader succeeded")
+	}
+	if _, err := store.Stat("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("failed Put left a blob behind: %v", err)
+	}
+}
+
+func testCopy(t *testing.T, store Store) {
+	mustPut(t, store, "src", "original")
+	if err := store.Copy("src", "dir/dst"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	wantBlob(t, store, "dir/dst", "original")
+
+	// Copies are independent: replacing or deleting one leaves the other alone.
+	mustPut(t, store, "src", "changed")
+	wantBlob(t, store, "dir/dst", "original")
+	if err := store.Delete("dir/dst"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	wantBlob(t, store, "src", "changed")
+
+	mustPut(t, store, "other", "replaced by a copy")
+	if err := store.Copy("src", "other"); err != nil {
+		t.Fatalf("Copy over a blob: %v", err)
+	}
+	wantBlob(t, store, "other", "changed")
+}
+
+func testList(t *testing.T, store Store) {
+	for _, name := range []string{"img/a", "img/sub/b", "imgx", "other/c"} {
+		mustPut(t, store, name, name)
+	}
+
+	list := func(prefix string) []string {
+		var names []string
+		err := store.List(prefix, func(info Info) error {
+			if info.Size != int64(len(info.Name)) {
+				t.Errorf("List reports %s with %d bytes, want %d", info.Name, info.Size, len(info.Name))
+			}
+			names = append(names, info.Name)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("List(%q): %v", prefix, err)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	for prefix, want := range map[string]string{
+		"":        "img/a img/sub/b imgx other/c",
+		"img/":    "img/a img/sub/b",
+		"img":     "img/a img/sub/b imgx",
+		"other/c": "other/c",
+		"none/":   "",
+	} {
+		if got := strings.Join(list(prefix), " "); got != want {
+			t.Errorf("List(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := store.List("", func(Info) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("List went on after fn failed: %d calls, %v", calls, err)
+	}
+}
+
+func TestReaderSize(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString("0123456789"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		r    io.Reader
+		want int64
+	}{
+		{"bytes", bytes.NewReader([]byte("abc")), 3},
+		{"strings", strings.NewReader("abcd"), 4},
+		{"file", file, 6},
+		{"sized", Sized(io.MultiReader(strings.NewReader("ab")), 2), 2},
+		{"stream", io.MultiReader(strings.NewReader("ab")), -1},
+	}
+	for _, tt := range tests {
+		if got := readerSize(tt.r); got != tt.want {
+			t.Errorf("readerSize of %s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,1038 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the embedded DB. Images holds every record, live or trashed, by image_id;
+// filenames points the filename of each live record at its image_id, and trash holds the
+// image_id of each trashed one. Versions holds a bucket of kept versions per image_id, keyed
+// by version number.
+var (
+	imagesBucket    = []byte("images")
+	filenamesBucket = []byte("filenames")
+	trashBucket     = []byte("trash")
+	versionsBucket  = []byte("versions")
+	blobsBucket     = []byte("blobs")
+	usageBucket     = []byte("namespace_usage")
+	uploadsBucket   = []byte("upload_sessions")
+)
+
+// BoltDB keeps the image records in a single file, for running without a Postgres server.
+// Only one process can open the file at a time.
+type BoltDB struct {
+	DB *bolt.DB
+}
+
+// contentRef is how many records refer to the content with a checksum.
+type contentRef struct {
+	Size int64
+	Refs int
+}
+
+func NewBoltDB(path string) (ImageDB, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{imagesBucket, filenamesBucket, versionsBucket, blobsBucket, usageBucket, uploadsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(trashBucket) == nil {
+			return indexTrash(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to prepare DB buckets: %w", err)
+	}
+
+	return &BoltDB{DB: db}, nil
+}
+
+func (d *BoltDB) SaveNewInfo(imageInfo ImagesInfo) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(filenamesBucket).Get([]byte(imageInfo.Filename)) != nil {
+			return ErrImgExists
+		}
+		return insertImage(tx, imageInfo)
+	})
+	if errors.Is(err, ErrImgExists) {
+		_, err := d.UpdateInfo(imageInfo)
+		if err != nil {
+			return fmt.Errorf("[Image DB] Error while updating new image info (SaveNewInfo): %w", err)
+		}
+
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while SAVING new image info: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateInfo replaces the record holding the Filename of imageInfo. When the content changes
+// the previous one is kept as a version of the image, which takes over its reference.
+func (d *BoltDB) UpdateInfo(imageInfo ImagesInfo) (string, error) {
+	var imageID string
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		previous, err := liveImage(tx, ImagesInfo{Filename: imageInfo.Filename})
+		if err != nil {
+			return err
+		}
+
+		// Records saved before checksums were kept have no content worth a version.
+		archive := previous.Checksum != "" && previous.Checksum != imageInfo.Checksum
+		updated := previous
+		updated.ChangedAt = imageInfo.ChangedAt
+		updated.Tags = tagsOrEmpty(imageInfo.Tags)
+		updated.Size = imageInfo.Size
+		updated.ContentType = imageInfo.ContentType
+		updated.Checksum = imageInfo.Checksum
+		if archive {
+			err = putVersion(tx, ImageVersion{
+				ImageId:     previous.ImageId,
+				Version:     previous.Version,
+				Size:        previous.Size,
+				ContentType: previous.ContentType,
+				Checksum:    previous.Checksum,
+				CreatedAt:   previous.ChangedAt,
+				ReplacedAt:  time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			updated.Version = previous.Version + 1
+		}
+		err = putImage(tx, updated)
+		if err != nil {
+			return err
+		}
+
+		if previous.Checksum != updated.Checksum {
+			if !archive {
+				err = boltDropRef(tx, previous.Checksum)
+				if err != nil {
+					return err
+				}
+			}
+			err = boltAddRef(tx, updated.Checksum, updated.Size)
+			if err != nil {
+				return err
+			}
+		}
+		imageID = updated.ImageId
+
+		return boltAddUsage(tx, previous.Namespace, updated.Size-previous.Size, 0)
+	})
+	if err != nil {
+		return "", fmt.Errorf("[Image DB] unable to update image info: %w", err)
+	}
+
+	return imageID, nil
+}
+
+func (d *BoltDB) GetAllInfo(files []string) ([]ImagesInfo, error) {
+	records := []ImagesInfo{}
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		for _, filename := range files {
+			record, err := liveImage(tx, ImagesInfo{Filename: filename})
+			if errors.Is(err, ErrImgNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			records = append(records, ImagesInfo{Filename: record.Filename, CreatedAt: record.CreatedAt, ChangedAt: record.ChangedAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to return records from DB: %w", err)
+	}
+
+	return records, nil
+}
+
+// ListInfo returns a page of the live records. In name order only the page is read off the
+// filename index; the date orders have to read every record with the prefix to sort them.
+func (d *BoltDB) ListInfo(opts ListOptions) ([]ImagesInfo, error) {
+	if opts.OrderBy == SortByName {
+		records, err := d.listByName(opts)
+		if err != nil {
+			return nil, fmt.Errorf("[Image DB] unable to list images: %w", err)
+		}
+		return records, nil
+	}
+
+	var records []ImagesInfo
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imagesBucket)
+		cursor := tx.Bucket(filenamesBucket).Cursor()
+		prefix := []byte(opts.Prefix)
+		for filename, imageID := cursor.Seek(prefix); filename != nil && bytes.HasPrefix(filename, prefix); filename, imageID = cursor.Next() {
+			var record ImagesInfo
+			if err := json.Unmarshal(images.Get(imageID), &record); err != nil {
+				return fmt.Errorf("record of %s: %w", filename, err)
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] unable to list images: %w", err)
+	}
+
+	key := func(record ImagesInfo) time.Time {
+		if opts.OrderBy == SortByChanged {
+			return record.ChangedAt
+		}
+		return record.CreatedAt
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if opts.Descending {
+			a, b = b, a
+		}
+		if !key(a).Equal(key(b)) {
+			return key(a).Before(key(b))
+		}
+		return a.Filename < b.Filename
+	})
+
+	return pageOf(records, opts), nil
+}
+
+// listByName walks the filename index from the first filename with the prefix of opts, or
+// back from the last one, skipping the offset and stopping once the page is full.
+func (d *BoltDB) listByName(opts ListOptions) ([]ImagesInfo, error) {
+	records := []ImagesInfo{}
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imagesBucket)
+		cursor := tx.Bucket(filenamesBucket).Cursor()
+		prefix := []byte(opts.Prefix)
+
+		first, next := cursor.Seek, cursor.Next
+		if opts.Descending {
+			first, next = func(prefix []byte) ([]byte, []byte) { return seekLast(cursor, prefix) }, cursor.Prev
+		}
+		skip := opts.Offset
+		for filename, imageID := first(prefix); filename != nil && bytes.HasPrefix(filename, prefix); filename, imageID = next() {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			var record ImagesInfo
+			if err := json.Unmarshal(images.Get(imageID), &record); err != nil {
+				return fmt.Errorf("record of %s: %w", filename, err)
+			}
+			records = append(records, record)
+			if opts.Limit > 0 && len(records) == opts.Limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	return records, err
+}
+
+// seekLast moves cursor to the last key starting with prefix, or to the key just before
+// where it would be if there is none.
+func seekLast(cursor *bolt.Cursor, prefix []byte) ([]byte, []byte) {
+	// The keys with prefix end before the prefix with its last byte below 0xff raised by one.
+	end := bytes.Clone(prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xff {
+		end = end[:len(end)-1]
+	}
+	if len(end) == 0 {
+		return cursor.Last()
+	}
+	end[len(end)-1]++
+
+	if key, _ := cursor.Seek(end); key == nil {
+		return cursor.Last()
+	}
+	return cursor.Prev()
+}
+
+// DeleteInfo removes the live record matching the ImageId of imageInfo or, if it is empty, its
+// Filename, for good.
+func (d *BoltDB) DeleteInfo(imageInfo ImagesInfo) (ImagesInfo, error) {
+	var deleted ImagesInfo
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = liveImage(tx, imageInfo)
+		if err != nil {
+			return err
+		}
+		return removeImage(tx, deleted)
+	})
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] Error while DELETING image info: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// TrashInfo moves the live record matching the ImageId of imageInfo or, if it is empty, its
+// Filename to the trash. A trashed image keeps its content and versions until it is purged.
+func (d *BoltDB) TrashInfo(imageInfo ImagesInfo) (ImagesInfo, error) {
+	var trashed ImagesInfo
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		trashed, err = liveImage(tx, imageInfo)
+		if err != nil {
+			return err
+		}
+		trashed.DeletedAt = time.Now()
+		return putImage(tx, trashed)
+	})
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] Error while trashing image info: %w", err)
+	}
+
+	return trashed, nil
+}
+
+// ListTrash returns a page of the trashed records, most recently deleted first. OrderBy and
+// Descending of opts are ignored.
+func (d *BoltDB) ListTrash(opts ListOptions) ([]ImagesInfo, error) {
+	records, err := d.trashed(func(record ImagesInfo) bool {
+		return strings.HasPrefix(record.Filename, opts.Prefix) && (opts.Namespace == "" || record.Namespace == opts.Namespace)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] unable to list trash: %w", err)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].DeletedAt.Equal(records[j].DeletedAt) {
+			return records[i].DeletedAt.After(records[j].DeletedAt)
+		}
+		return records[i].Filename < records[j].Filename
+	})
+
+	return pageOf(records, opts), nil
+}
+
+// trashed returns the trashed records match accepts.
+func (d *BoltDB) trashed(match func(ImagesInfo) bool) ([]ImagesInfo, error) {
+	records := []ImagesInfo{}
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		return forEachTrashed(tx, func(record ImagesInfo) error {
+			if match(record) {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+// forEachTrashed calls fn with every trashed record. It reads the trash index, so it costs
+// as much as the trash is large, not the whole store; the trash is not indexed by filename or
+// namespace, so the callers filter what fn is given.
+func forEachTrashed(tx *bolt.Tx, fn func(ImagesInfo) error) error {
+	images := tx.Bucket(imagesBucket)
+	return tx.Bucket(trashBucket).ForEach(func(imageID, _ []byte) error {
+		var record ImagesInfo
+		if err := json.Unmarshal(images.Get(imageID), &record); err != nil {
+			return fmt.Errorf("record of %s: %w", imageID, err)
+		}
+		return fn(record)
+	})
+}
+
+// indexTrash creates the trash index of a DB written before there was one.
+func indexTrash(tx *bolt.Tx) error {
+	trash, err := tx.CreateBucket(trashBucket)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(imagesBucket).ForEach(func(imageID, value []byte) error {
+		var record ImagesInfo
+		if err := json.Unmarshal(value, &record); err != nil {
+			return fmt.Errorf("record of %s: %w", imageID, err)
+		}
+		if record.DeletedAt.IsZero() {
+			return nil
+		}
+		return trash.Put(imageID, nil)
+	})
+}
+
+// RestoreInfo takes the trashed record matching the ImageId of imageInfo or, if it is empty,
+// the one with its Filename deleted last out of the trash. ErrImgExists is returned when a
+// live record holds the filename. With a Namespace in imageInfo, records of that namespace
+// come first and ErrOtherNamespace is returned for a record of another.
+func (d *BoltDB) RestoreInfo(imageInfo ImagesInfo) (ImagesInfo, error) {
+	var restored ImagesInfo
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		found := false
+		err := forEachTrashed(tx, func(record ImagesInfo) error {
+			matches := record.Filename == imageInfo.Filename
+			if imageInfo.ImageId != "" {
+				matches = record.ImageId == imageInfo.ImageId
+			}
+			if !matches {
+				return nil
+			}
+			own, ownRestored := record.Namespace == imageInfo.Namespace, restored.Namespace == imageInfo.Namespace
+			if !found || own && !ownRestored || own == ownRestored && record.DeletedAt.After(restored.DeletedAt) {
+				restored, found = record, true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%s: %w", boltKey(imageInfo), ErrImgNotFound)
+		}
+		if imageInfo.Namespace != "" && restored.Namespace != imageInfo.Namespace {
+			return fmt.Errorf("%s is kept in namespace %q: %w", boltKey(imageInfo), restored.Namespace, ErrOtherNamespace)
+		}
+		if tx.Bucket(filenamesBucket).Get([]byte(restored.Filename)) != nil {
+			return fmt.Errorf("%s: %w", boltKey(imageInfo), ErrImgExists)
+		}
+
+		restored.DeletedAt = time.Time{}
+		return putImage(tx, restored)
+	})
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] Error while restoring image info: %w", err)
+	}
+
+	return restored, nil
+}
+
+// PurgeTrash removes the records of namespace, or of every namespace if it is empty, trashed
+// before the given time for good, together with their versions, and returns how many it
+// removed. Their content is left to garbage collection.
+func (d *BoltDB) PurgeTrash(namespace string, before time.Time) (int, error) {
+	var purged int
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		var expired []ImagesInfo
+		err := forEachTrashed(tx, func(record ImagesInfo) error {
+			if record.DeletedAt.Before(before) && (namespace == "" || record.Namespace == namespace) {
+				expired = append(expired, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, record := range expired {
+			if err := removeImage(tx, record); err != nil {
+				return err
+			}
+		}
+		purged = len(expired)
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("[Image DB] Error while purging trash: %w", err)
+	}
+
+	return purged, nil
+}
+
+// GetInfo returns the live record matching the ImageId of imageInfo or, if it is empty, its Filename.
+func (d *BoltDB) GetInfo(imageInfo ImagesInfo) (ImagesInfo, error) {
+	var record ImagesInfo
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = liveImage(tx, imageInfo)
+		return err
+	})
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to read image info: %w", err)
+	}
+
+	return record, nil
+}
+
+// RenameInfo moves the record of imageInfo to newFilename, keeping its image_id and setting
+// changed_at from imageInfo. With overwrite a record already holding newFilename is replaced,
+// otherwise ErrImgExists is returned.
+func (d *BoltDB) RenameInfo(imageInfo ImagesInfo, newFilename string, overwrite bool) (ImagesInfo, error) {
+	var renamed ImagesInfo
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		if overwrite {
+			if err := boltReplaceInfo(tx, newFilename); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		renamed, err = liveImage(tx, imageInfo)
+		if err != nil {
+			return err
+		}
+		if renamed.Filename != newFilename && tx.Bucket(filenamesBucket).Get([]byte(newFilename)) != nil {
+			return fmt.Errorf("%s: %w", newFilename, ErrImgExists)
+		}
+
+		err = tx.Bucket(filenamesBucket).Delete([]byte(renamed.Filename))
+		if err != nil {
+			return err
+		}
+		renamed.Filename = newFilename
+		renamed.ChangedAt = imageInfo.ChangedAt
+		return putImage(tx, renamed)
+	})
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] Error while renaming image info: %w", err)
+	}
+
+	return renamed, nil
+}
+
+// CopyInfo inserts newImage as a new record. With overwrite a record already holding its
+// filename is replaced, otherwise ErrImgExists is returned.
+func (d *BoltDB) CopyInfo(newImage ImagesInfo, overwrite bool) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		if overwrite {
+			if err := boltReplaceInfo(tx, newImage.Filename); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket(filenamesBucket).Get([]byte(newImage.Filename)) != nil {
+			return fmt.Errorf("%s: %w", newImage.Filename, ErrImgExists)
+		}
+
+		return insertImage(tx, newImage)
+	})
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while copying image info: %w", err)
+	}
+
+	return nil
+}
+
+// ListVersions returns the kept earlier versions of an image, newest first.
+func (d *BoltDB) ListVersions(imageID string) ([]ImageVersion, error) {
+	versions := []ImageVersion{}
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		kept := tx.Bucket(versionsBucket).Bucket([]byte(imageID))
+		if kept == nil {
+			return nil
+		}
+		cursor := kept.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var version ImageVersion
+			if err := json.Unmarshal(value, &version); err != nil {
+				return err
+			}
+			versions = append(versions, version)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] unable to list image versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+func (d *BoltDB) GetVersion(imageID string, version int) (ImageVersion, error) {
+	var found ImageVersion
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		var value []byte
+		if kept := tx.Bucket(versionsBucket).Bucket([]byte(imageID)); kept != nil {
+			value = kept.Get(versionKey(version))
+		}
+		if value == nil {
+			return fmt.Errorf("version %d of %s: %w", version, imageID, ErrVersionNotFound)
+		}
+		return json.Unmarshal(value, &found)
+	})
+	if err != nil {
+		return ImageVersion{}, fmt.Errorf("[Image DB] unable to read image version: %w", err)
+	}
+
+	return found, nil
+}
+
+// DeleteOldVersions removes the kept versions that have more than keepLast newer kept versions
+// of the same image or were replaced before the given time, and returns how many it removed.
+// A zero keepLast or before disables that rule.
+func (d *BoltDB) DeleteOldVersions(keepLast int, before time.Time) (int, error) {
+	var deleted int
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(versionsBucket).ForEachBucket(func(imageID []byte) error {
+			kept := tx.Bucket(versionsBucket).Bucket(imageID)
+			var old []ImageVersion
+			newer := 0
+			cursor := kept.Cursor()
+			for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+				var version ImageVersion
+				if err := json.Unmarshal(value, &version); err != nil {
+					return err
+				}
+				newer++
+				if (keepLast > 0 && newer > keepLast) || version.ReplacedAt.Before(before) {
+					old = append(old, version)
+				}
+			}
+
+			for _, version := range old {
+				if err := kept.Delete(versionKey(version.Version)); err != nil {
+					return err
+				}
+				if err := boltDropRef(tx, version.Checksum); err != nil {
+					return err
+				}
+			}
+			deleted += len(old)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("[Image DB] Error while deleting old image versions: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// ContentRefs returns how many images refer to each of checksums. Content the DB does not
+// know is left out of the map.
+func (d *BoltDB) ContentRefs(checksums []string) (map[string]int, error) {
+	refs := make(map[string]int, len(checksums))
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		for _, checksum := range checksums {
+			ref, ok, err := getRef(tx, checksum)
+			if err != nil {
+				return err
+			}
+			if ok {
+				refs[checksum] = ref.Refs
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] unable to read content references: %w", err)
+	}
+
+	return refs, nil
+}
+
+// DeleteUnreferencedContent forgets those of checksums that no image refers to.
+func (d *BoltDB) DeleteUnreferencedContent(checksums []string) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		for _, checksum := range checksums {
+			ref, ok, err := getRef(tx, checksum)
+			if err != nil {
+				return err
+			}
+			if ok && ref.Refs <= 0 {
+				if err := tx.Bucket(blobsBucket).Delete([]byte(checksum)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while deleting unreferenced content: %w", err)
+	}
+
+	return nil
+}
+
+// GetUsage returns the usage of namespace; a namespace without images uses nothing.
+func (d *BoltDB) GetUsage(namespace string) (Usage, error) {
+	var usage Usage
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(usageBucket).Get([]byte(namespace))
+		if value == nil {
+			return nil
+		}
+		return json.Unmarshal(value, &usage)
+	})
+	if err != nil {
+		return Usage{}, fmt.Errorf("[Image DB] unable to read namespace usage: %w", err)
+	}
+
+	return usage, nil
+}
+
+// TotalUsage returns the usage of all namespaces together.
+func (d *BoltDB) TotalUsage() (Usage, error) {
+	var total Usage
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usageBucket).ForEach(func(_, value []byte) error {
+			var usage Usage
+			if err := json.Unmarshal(value, &usage); err != nil {
+				return err
+			}
+			total.Bytes += usage.Bytes
+			total.Files += usage.Files
+			return nil
+		})
+	})
+	if err != nil {
+		return Usage{}, fmt.Errorf("[Image DB] unable to read storage usage: %w", err)
+	}
+
+	return total, nil
+}
+
+func (d *BoltDB) SaveUploadSession(session UploadSession) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(uploadsBucket).Get([]byte(session.UploadID)) != nil {
+			return fmt.Errorf("upload session %s already exists", session.UploadID)
+		}
+		session.Tags = tagsOrEmpty(session.Tags)
+		session.Namespace = namespaceOrDefault(session.Namespace)
+		session.UpdatedAt = session.CreatedAt
+		return putJSON(tx.Bucket(uploadsBucket), []byte(session.UploadID), session)
+	})
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while SAVING upload session: %w", err)
+	}
+
+	return nil
+}
+
+func (d *BoltDB) GetUploadSession(uploadID string) (UploadSession, error) {
+	var session UploadSession
+	err := d.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		session, err = getUploadSession(tx, uploadID)
+		return err
+	})
+	if err != nil {
+		return UploadSession{}, fmt.Errorf("[Image DB] unable to read upload session: %w", err)
+	}
+
+	return session, nil
+}
+
+func (d *BoltDB) UpdateUploadSession(uploadID string, received int64) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		session, err := getUploadSession(tx, uploadID)
+		if err != nil {
+			return err
+		}
+		session.Received = received
+		session.UpdatedAt = time.Now()
+		return putJSON(tx.Bucket(uploadsBucket), []byte(uploadID), session)
+	})
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while updating upload session: %w", err)
+	}
+
+	return nil
+}
+
+func (d *BoltDB) DeleteUploadSession(uploadID string) error {
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(uploadsBucket).Delete([]byte(uploadID))
+	})
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while deleting upload session: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredUploadSessions removes sessions not touched since before and returns their IDs.
+func (d *BoltDB) DeleteExpiredUploadSessions(before time.Time) ([]string, error) {
+	var ids []string
+	err := d.DB.Update(func(tx *bolt.Tx) error {
+		uploads := tx.Bucket(uploadsBucket)
+		err := uploads.ForEach(func(key, value []byte) error {
+			var session UploadSession
+			if err := json.Unmarshal(value, &session); err != nil {
+				return err
+			}
+			if session.UpdatedAt.Before(before) {
+				ids = append(ids, session.UploadID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := uploads.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] Error while deleting expired upload sessions: %w", err)
+	}
+
+	return ids, nil
+}
+
+func (d *BoltDB) Close() {
+	d.DB.Close()
+}
+
+// boltKey names the record imageInfo selects in errors, like imageKey does for Postgres.
+func boltKey(imageInfo ImagesInfo) string {
+	if imageInfo.ImageId != "" {
+		return imageInfo.ImageId
+	}
+	return imageInfo.Filename
+}
+
+// liveImage returns the live record matching the ImageId of imageInfo or, if it is empty, its
+// Filename.
+func liveImage(tx *bolt.Tx, imageInfo ImagesInfo) (ImagesInfo, error) {
+	imageID := []byte(imageInfo.ImageId)
+	if imageInfo.ImageId == "" {
+		imageID = tx.Bucket(filenamesBucket).Get([]byte(imageInfo.Filename))
+	}
+
+	var record ImagesInfo
+	value := tx.Bucket(imagesBucket).Get(imageID)
+	if value != nil {
+		if err := json.Unmarshal(value, &record); err != nil {
+			return ImagesInfo{}, err
+		}
+	}
+	if value == nil || !record.DeletedAt.IsZero() {
+		return ImagesInfo{}, fmt.Errorf("%s: %w", boltKey(imageInfo), ErrImgNotFound)
+	}
+
+	return record, nil
+}
+
+// insertImage stores imageInfo as a new live record and charges its content and namespace.
+func insertImage(tx *bolt.Tx, imageInfo ImagesInfo) error {
+	if tx.Bucket(imagesBucket).Get([]byte(imageInfo.ImageId)) != nil {
+		return fmt.Errorf("image id %s: %w", imageInfo.ImageId, ErrImgExists)
+	}
+
+	imageInfo.Namespace = namespaceOrDefault(imageInfo.Namespace)
+	imageInfo.Tags = tagsOrEmpty(imageInfo.Tags)
+	imageInfo.Version = 1
+	imageInfo.DeletedAt = time.Time{}
+	err := putImage(tx, imageInfo)
+	if err != nil {
+		return err
+	}
+	err = boltAddRef(tx, imageInfo.Checksum, imageInfo.Size)
+	if err != nil {
+		return err
+	}
+
+	return boltAddUsage(tx, imageInfo.Namespace, imageInfo.Size, 1)
+}
+
+// putImage writes record and keeps the filename and trash indexes in step with whether it is live.
+func putImage(tx *bolt.Tx, record ImagesInfo) error {
+	err := putJSON(tx.Bucket(imagesBucket), []byte(record.ImageId), record)
+	if err != nil {
+		return err
+	}
+
+	filenames, trash := tx.Bucket(filenamesBucket), tx.Bucket(trashBucket)
+	if !record.DeletedAt.IsZero() {
+		if bytes.Equal(filenames.Get([]byte(record.Filename)), []byte(record.ImageId)) {
+			if err := filenames.Delete([]byte(record.Filename)); err != nil {
+				return err
+			}
+		}
+		return trash.Put([]byte(record.ImageId), nil)
+	}
+
+	err = trash.Delete([]byte(record.ImageId))
+	if err != nil {
+		return err
+	}
+	return filenames.Put([]byte(record.Filename), []byte(record.ImageId))
+}
+
+// removeImage deletes record for good, releasing its content, its versions and its usage.
+func removeImage(tx *bolt.Tx, record ImagesInfo) error {
+	err := tx.Bucket(imagesBucket).Delete([]byte(record.ImageId))
+	if err != nil {
+		return err
+	}
+	if record.DeletedAt.IsZero() {
+		err = tx.Bucket(filenamesBucket).Delete([]byte(record.Filename))
+	} else {
+		err = tx.Bucket(trashBucket).Delete([]byte(record.ImageId))
+	}
+	if err != nil {
+		return err
+	}
+
+	err = boltDropRef(tx, record.Checksum)
+	if err != nil {
+		return err
+	}
+	err = boltAddUsage(tx, record.Namespace, -record.Size, -1)
+	if err != nil {
+		return err
+	}
+
+	versions := tx.Bucket(versionsBucket)
+	kept := versions.Bucket([]byte(record.ImageId))
+	if kept == nil {
+		return nil
+	}
+	err = kept.ForEach(func(_, value []byte) error {
+		var version ImageVersion
+		if err := json.Unmarshal(value, &version); err != nil {
+			return err
+		}
+		return boltDropRef(tx, version.Checksum)
+	})
+	if err != nil {
+		return err
+	}
+
+	return versions.DeleteBucket([]byte(record.ImageId))
+}
+
+// boltReplaceInfo moves the record holding filename, if there is one, to the trash to make
+// room for another.
+func boltReplaceInfo(tx *bolt.Tx, filename string) error {
+	record, err := liveImage(tx, ImagesInfo{Filename: filename})
+	if errors.Is(err, ErrImgNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	record.DeletedAt = time.Now()
+	return putImage(tx, record)
+}
+
+func putVersion(tx *bolt.Tx, version ImageVersion) error {
+	kept, err := tx.Bucket(versionsBucket).CreateBucketIfNotExists([]byte(version.ImageId))
+	if err != nil {
+		return err
+	}
+
+	return putJSON(kept, versionKey(version.Version), version)
+}
+
+// versionKey orders the versions of an image by number.
+func versionKey(version int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(version))
+}
+
+func getRef(tx *bolt.Tx, checksum string) (contentRef, bool, error) {
+	value := tx.Bucket(blobsBucket).Get([]byte(checksum))
+	if value == nil {
+		return contentRef{}, false, nil
+	}
+
+	var ref contentRef
+	err := json.Unmarshal(value, &ref)
+	return ref, err == nil, err
+}
+
+// boltAddRef counts one more image referring to the content with checksum, registering the
+// content if it is new.
+func boltAddRef(tx *bolt.Tx, checksum string, size int64) error {
+	if checksum == "" {
+		return nil
+	}
+
+	ref, ok, err := getRef(tx, checksum)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		ref.Size = size
+	}
+	ref.Refs++
+
+	return putJSON(tx.Bucket(blobsBucket), []byte(checksum), ref)
+}
+
+// boltDropRef counts one image less referring to the content with checksum. Content nothing
+// refers to keeps its entry until garbage collection removes it together with the blob.
+func boltDropRef(tx *bolt.Tx, checksum string) error {
+	ref, ok, err := getRef(tx, checksum)
+	if err != nil || !ok || ref.Refs <= 0 {
+		return err
+	}
+	ref.Refs--
+
+	return putJSON(tx.Bucket(blobsBucket), []byte(checksum), ref)
+}
+
+// boltAddUsage adds bytes and files to the usage of namespace.
+func boltAddUsage(tx *bolt.Tx, namespace string, bytes, files int64) error {
+	if bytes == 0 && files == 0 {
+		return nil
+	}
+
+	var usage Usage
+	usages := tx.Bucket(usageBucket)
+	if value := usages.Get([]byte(namespace)); value != nil {
+		if err := json.Unmarshal(value, &usage); err != nil {
+			return err
+		}
+	}
+	usage.Bytes += bytes
+	usage.Files += files
+
+	return putJSON(usages, []byte(namespace), usage)
+}
+
+func getUploadSession(tx *bolt.Tx, uploadID string) (UploadSession, error) {
+	value := tx.Bucket(uploadsBucket).Get([]byte(uploadID))
+	if value == nil {
+		return UploadSession{}, fmt.Errorf("upload session %s: %w", uploadID, ErrUploadNotFound)
+	}
+
+	var session UploadSession
+	err := json.Unmarshal(value, &session)
+	return session, err
+}
+
+func putJSON(bucket *bolt.Bucket, key []byte, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(key, data)
+}
+
+// pageOf cuts the page opts asks for out of records.
+func pageOf(records []ImagesInfo, opts ListOptions) []ImagesInfo {
+	if opts.Offset >= len(records) {
+		return []ImagesInfo{}
+	}
+	records = records[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(records) {
+		records = records[:opts.Limit]
+	}
+
+	return records
+}
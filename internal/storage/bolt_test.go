@@ -0,0 +1,86 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/Niiazgulov/tages.git/internal/storage/storagetest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltDB(t *testing.T) {
+	storagetest.TestImageDB(t, storagetest.Bolt)
+}
+
+func TestBoltDBReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tages.db")
+
+	repo, err := storage.NewBoltDB(path)
+	if err != nil {
+		t.Fatalf("NewBoltDB: %v", err)
+	}
+	record := storage.ImagesInfo{ImageId: "4f7d1a4e-7a7e-4c38-9a55-2f4f2f8b4d3e", Filename: "a.jpg", Size: 3, Checksum: "abc"}
+	if err := repo.SaveNewInfo(record); err != nil {
+		t.Fatalf("SaveNewInfo: %v", err)
+	}
+	repo.Close()
+
+	// Records and usage survive a restart of the server.
+	repo, err = storage.NewBoltDB(path)
+	if err != nil {
+		t.Fatalf("NewBoltDB after closing: %v", err)
+	}
+	defer repo.Close()
+
+	got, err := repo.GetInfo(storage.ImagesInfo{Filename: "a.jpg"})
+	if err != nil || got.ImageId != record.ImageId || got.Size != 3 {
+		t.Errorf("GetInfo after reopening = %+v, %v", got, err)
+	}
+	usage, err := repo.GetUsage(storage.DefaultNamespace)
+	if err != nil || usage.Bytes != 3 || usage.Files != 1 {
+		t.Errorf("usage after reopening = %+v, %v, want 3 bytes in 1 file", usage, err)
+	}
+}
+
+func TestBoltDBIndexesOldTrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tages.db")
+
+	repo, err := storage.NewBoltDB(path)
+	if err != nil {
+		t.Fatalf("NewBoltDB: %v", err)
+	}
+	record := storage.ImagesInfo{ImageId: "4f7d1a4e-7a7e-4c38-9a55-2f4f2f8b4d3e", Filename: "a.jpg", Size: 3, Checksum: "abc"}
+	if err := repo.SaveNewInfo(record); err != nil {
+		t.Fatalf("SaveNewInfo: %v", err)
+	}
+	if _, err := repo.TrashInfo(storage.ImagesInfo{Filename: "a.jpg"}); err != nil {
+		t.Fatalf("TrashInfo: %v", err)
+	}
+	repo.Close()
+
+	// A file written before the trash was indexed has no index of it.
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error { return tx.DeleteBucket([]byte("trash")) })
+	db.Close()
+	if err != nil {
+		t.Fatalf("cannot drop the trash index: %v", err)
+	}
+
+	repo, err = storage.NewBoltDB(path)
+	if err != nil {
+		t.Fatalf("NewBoltDB after dropping the index: %v", err)
+	}
+	defer repo.Close()
+
+	trash, err := repo.ListTrash(storage.ListOptions{Limit: 10})
+	if err != nil || len(trash) != 1 || trash[0].ImageId != record.ImageId {
+		t.Errorf("ListTrash after reopening = %+v, %v, want a.jpg", trash, err)
+	}
+	if _, err := repo.RestoreInfo(storage.ImagesInfo{Filename: "a.jpg"}); err != nil {
+		t.Errorf("RestoreInfo after reopening: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/storage/blob"
+)
+
+// contentPrefix is where the blob store keeps image content, each blob named by the hex
+// SHA-256 of its data. Images refer to their content by checksum, so identical images
+// share one blob.
+const contentPrefix = "sha256/"
+
+// contentKey names the blob holding the content with checksum. The blobs are spread over two
+// levels of folders named by the leading hex digits of the checksum, so that on disk no
+// folder holds more than a small share of the images.
+func contentKey(checksum string) string {
+	return contentPrefix + checksum[:2] + "/" + checksum[2:4] + "/" + checksum
+}
+
+// cleanChecksum lowercases a hex SHA-256 and reports whether it is one.
+func cleanChecksum(checksum string) (string, bool) {
+	checksum = strings.ToLower(checksum)
+	decoded, err := hex.DecodeString(checksum)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", false
+	}
+
+	return checksum, true
+}
+
+// listContent calls fn for every content blob in its place, skipping the unfinished writes
+// of the backend and content still waiting for MigrateContentLayout.
+func (store *ImageStore) listContent(fn func(checksum string, info blob.Info)) error {
+	err := store.blobs.List(contentPrefix, func(info blob.Info) error {
+		checksum, ok := cleanChecksum(path.Base(info.Name))
+		if ok && info.Name == contentKey(checksum) {
+			fn(checksum, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return blobError("cannot list image content", err)
+	}
+
+	return nil
+}
+
+// CollectGarbage deletes the content no image refers to, if it was stored before the given
+// time. The grace period lets new uploads of recently released content reuse it; it also
+// covers content written by a save that crashed before its record was committed.
+func (store *ImageStore) CollectGarbage(repo ImageDB, before time.Time) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var candidates []string
+	err := store.listContent(func(checksum string, info blob.Info) {
+		if info.ModTime.Before(before) {
+			candidates = append(candidates, checksum)
+		}
+	})
+	if err != nil || len(candidates) == 0 {
+		return 0, err
+	}
+
+	refs, err := repo.ContentRefs(candidates)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read content references: %w", err)
+	}
+	var garbage []string
+	for _, checksum := range candidates {
+		if refs[checksum] == 0 {
+			garbage = append(garbage, checksum)
+		}
+	}
+	if len(garbage) == 0 {
+		return 0, nil
+	}
+
+	// Forget the content before deleting it, so the DB never counts references to a missing blob.
+	err = repo.DeleteUnreferencedContent(garbage)
+	if err != nil {
+		return 0, fmt.Errorf("cannot delete unreferenced content: %w", err)
+	}
+	for i, checksum := range garbage {
+		if err := store.blobs.Delete(contentKey(checksum)); err != nil {
+			return i, blobError("cannot delete unreferenced content", err)
+		}
+	}
+
+	return len(garbage), nil
+}
+
+// ImportLegacyImages moves the images stored under their filename, as they were before content
+// was kept by checksum, into the content store and points their records at it. It returns how
+// many were imported and how many were left in place because no record refers to them.
+func (store *ImageStore) ImportLegacyImages(repo ImageDB) (int, int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var legacy []string
+	err := store.blobs.List("", func(info blob.Info) error {
+		if !strings.HasPrefix(info.Name, ".") && !strings.Contains(info.Name, "/") {
+			legacy = append(legacy, info.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, blobError("cannot list legacy images", err)
+	}
+
+	imported, skipped := 0, 0
+	for _, filename := range legacy {
+		record, err := repo.GetInfo(ImagesInfo{Filename: filename})
+		if errors.Is(err, ErrImgNotFound) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return imported, skipped, err
+		}
+
+		err = store.importLegacyImage(record, repo)
+		if err != nil {
+			return imported, skipped, fmt.Errorf("cannot import %s: %w", filename, err)
+		}
+		imported++
+	}
+
+	return imported, skipped, nil
+}
+
+func (store *ImageStore) importLegacyImage(record ImagesInfo, repo ImageDB) error {
+	reader, info, err := store.blobs.Get(record.Filename, 0, 0)
+	if err != nil {
+		return blobError("cannot open image", err)
+	}
+	hash := sha256.New()
+	head := &headWriter{}
+	_, err = io.Copy(io.MultiWriter(hash, head), reader)
+	reader.Close()
+	if err != nil {
+		return blobError("cannot read image", err)
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	key := contentKey(checksum)
+	stored, err := store.exists(key)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		err = store.blobs.Copy(record.Filename, key)
+		if err != nil {
+			return blobError("cannot move image into the content store", err)
+		}
+	}
+
+	if record.Checksum != checksum || record.Size != info.Size || record.ContentType == "" {
+		record.Checksum = checksum
+		record.Size = info.Size
+		if record.ContentType == "" {
+			record.ContentType = http.DetectContentType(head.data)
+		}
+		_, err = repo.UpdateInfo(record)
+		if err != nil {
+			return fmt.Errorf("cannot update image info: %w", err)
+		}
+	}
+
+	err = store.blobs.Delete(record.Filename)
+	if err != nil {
+		return blobError("cannot remove legacy image", err)
+	}
+
+	return nil
+}
+
+// MigrateContentLayout moves the content stored directly under contentPrefix, as it was
+// before content was spread over folders, to where contentKey expects it. It returns how
+// many blobs were moved; a blob moved before a failure stays moved, so it can be rerun.
+func (store *ImageStore) MigrateContentLayout() (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var flat []string
+	err := store.blobs.List(contentPrefix, func(info blob.Info) error {
+		if checksum, ok := cleanChecksum(strings.TrimPrefix(info.Name, contentPrefix)); ok {
+			flat = append(flat, checksum)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, blobError("cannot list image content", err)
+	}
+
+	for i, checksum := range flat {
+		stored, err := store.exists(contentKey(checksum))
+		if err != nil {
+			return i, err
+		}
+		if !stored {
+			err = store.blobs.Copy(contentPrefix+checksum, contentKey(checksum))
+			if err != nil {
+				return i, blobError("cannot move image content", err)
+			}
+		}
+		err = store.blobs.Delete(contentPrefix + checksum)
+		if err != nil {
+			return i, blobError("cannot remove image content from its old place", err)
+		}
+	}
+
+	return len(flat), nil
+}
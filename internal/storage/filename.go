@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFilenameLength is the limit of most file systems on a single path element, in bytes.
+const maxFilenameLength = 255
+
+// reservedNames cannot be opened as regular files on Windows, whatever their extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// CleanFilename normalizes filename to Unicode NFC and checks that it names a single file
+// directly inside the image folder. Separators, and with them traversal and absolute paths,
+// control characters, reserved names and names longer than maxFilenameLength bytes are
+// rejected with ErrInvalidName. Names starting with a dot are kept for the store's own files.
+func CleanFilename(filename string) (string, error) {
+	if !utf8.ValidString(filename) {
+		return "", fmt.Errorf("%q is not valid UTF-8: %w", filename, ErrInvalidName)
+	}
+	filename = norm.NFC.String(filename)
+
+	switch {
+	case filename == "":
+		return "", fmt.Errorf("filename is empty: %w", ErrInvalidName)
+	case len(filename) > maxFilenameLength:
+		return "", fmt.Errorf("filename is %d bytes long, limit %d: %w", len(filename), maxFilenameLength, ErrInvalidName)
+	case strings.ContainsAny(filename, `/\`):
+		return "", fmt.Errorf("%q contains a path separator: %w", filename, ErrInvalidName)
+	case strings.HasPrefix(filename, "."):
+		return "", fmt.Errorf("%q starts with a dot: %w", filename, ErrInvalidName)
+	case strings.HasSuffix(filename, " ") || strings.HasSuffix(filename, "."):
+		return "", fmt.Errorf("%q ends with a space or a dot: %w", filename, ErrInvalidName)
+	}
+
+	for _, r := range filename {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("%q contains a control character: %w", filename, ErrInvalidName)
+		}
+	}
+
+	base, _, _ := strings.Cut(filename, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		return "", fmt.Errorf("%q is a reserved name: %w", filename, ErrInvalidName)
+	}
+
+	return filename, nil
+}
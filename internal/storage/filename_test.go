@@ -0,0 +1,62 @@
+package storage_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+)
+
+func FuzzCleanFilename(f *testing.F) {
+	for _, seed := range []string{
+		"photo.jpg", "фото.jpg", "cafe\u0301.jpg", "a..b.png",
+		"../x", "a/../../b", "..", ".", "/etc/passwd", `C:\x`, "C:x", `\\server\share`,
+		"CON.txt", "con", "nul .txt", "LPT1",
+		"a\x00b", "a\nb", "\x7f", "a\u0085b", "\xff\xfe",
+		"trailing.", "trailing ", ".hidden",
+		strings.Repeat("a", 255), strings.Repeat("a", 256), strings.Repeat("é", 128),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		clean, err := storage.CleanFilename(name)
+		if err != nil {
+			if !errors.Is(err, storage.ErrInvalidName) {
+				t.Fatalf("CleanFilename(%q) failed with %v, not ErrInvalidName", name, err)
+			}
+			return
+		}
+
+		if !filepath.IsLocal(clean) {
+			t.Errorf("%q is accepted as %q, which is not a local path", name, clean)
+		}
+		if strings.ContainsAny(clean, `/\`) {
+			t.Errorf("%q is accepted as %q, which contains a separator", name, clean)
+		}
+		if clean == ".." || clean == "." {
+			t.Errorf("%q is accepted as %q, which names a directory", name, clean)
+		}
+		if strings.HasPrefix(clean, ".") {
+			t.Errorf("%q is accepted as %q, which starts with a dot", name, clean)
+		}
+		if len(clean) > 255 {
+			t.Errorf("%q is accepted as %q, which is %d bytes long", name, clean, len(clean))
+		}
+		if !utf8.ValidString(clean) {
+			t.Errorf("%q is accepted as %q, which is not valid UTF-8", name, clean)
+		}
+		if i := strings.IndexFunc(clean, unicode.IsControl); i >= 0 {
+			t.Errorf("%q is accepted as %q, which has a control character at %d", name, clean, i)
+		}
+
+		again, err := storage.CleanFilename(clean)
+		if err != nil || again != clean {
+			t.Errorf("cleaning %q again gives %q, %v", clean, again, err)
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/storage/blob"
+	"github.com/google/uuid"
+)
+
+// quarantineFolder receives the content that no record refers to.
+const quarantineFolder = ".quarantine"
+
+// FsckOptions selects what Fsck repairs. Without DryRun it applies the enabled repairs;
+// RecreateRows and QuarantineFiles both handle content without records and are exclusive.
+type FsckOptions struct {
+	DryRun          bool
+	RecreateRows    bool
+	QuarantineFiles bool
+	DeleteRows      bool
+}
+
+// FsckReport lists the inconsistencies Fsck found between the blob store and the DB and
+// what was done about them. Files are named by checksum, rows by filename. With DryRun the
+// repair lists tell what would have been done.
+type FsckReport struct {
+	CheckedAt        time.Time `json:"checked_at"`
+	DryRun           bool      `json:"dry_run"`
+	Files            int       `json:"files"`
+	Rows             int       `json:"rows"`
+	FilesWithoutRows []string  `json:"files_without_rows"`
+	RowsWithoutFiles []string  `json:"rows_without_files"`
+	RecreatedRows    []string  `json:"recreated_rows,omitempty"`
+	Quarantined      []string  `json:"quarantined,omitempty"`
+	DeletedRows      []string  `json:"deleted_rows,omitempty"`
+	Errors           []string  `json:"errors,omitempty"`
+}
+
+// Consistent reports whether the blob store and the DB agreed when the check ran.
+func (r FsckReport) Consistent() bool {
+	return len(r.FilesWithoutRows) == 0 && len(r.RowsWithoutFiles) == 0
+}
+
+// Fsck compares the content in the blob store with the image records referring to it. Writes
+// are blocked while it runs, so it sees no half-saved images. Content the DB knows of but no
+// image refers to is either kept as an earlier version or waiting for garbage collection, and
+// is not reported. A failed repair is noted in the report and does not stop the others.
+func (store *ImageStore) Fsck(repo ImageDB, opts FsckOptions) (FsckReport, error) {
+	if opts.RecreateRows && opts.QuarantineFiles {
+		return FsckReport{}, errors.New("recreating rows and quarantining files are mutually exclusive")
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	report := FsckReport{
+		CheckedAt:        time.Now(),
+		DryRun:           opts.DryRun,
+		FilesWithoutRows: []string{},
+		RowsWithoutFiles: []string{},
+	}
+
+	files := []string{}
+	err := store.listContent(func(checksum string, _ blob.Info) {
+		files = append(files, checksum)
+	})
+	if err != nil {
+		return report, err
+	}
+	sort.Strings(files)
+	report.Files = len(files)
+
+	rows, err := allRecords(repo)
+	if err != nil {
+		return report, fmt.Errorf("cannot read image records: %w", err)
+	}
+	report.Rows = len(rows)
+
+	referenced := make(map[string]bool, len(rows))
+	for _, record := range rows {
+		referenced[record.Checksum] = true
+	}
+	var unreferenced []string
+	for _, checksum := range files {
+		if !referenced[checksum] {
+			unreferenced = append(unreferenced, checksum)
+		}
+	}
+	refs := map[string]int{}
+	if len(unreferenced) > 0 {
+		refs, err = repo.ContentRefs(unreferenced)
+		if err != nil {
+			return report, fmt.Errorf("cannot read content references: %w", err)
+		}
+	}
+
+	for _, checksum := range unreferenced {
+		if _, known := refs[checksum]; known {
+			continue
+		}
+		report.FilesWithoutRows = append(report.FilesWithoutRows, checksum)
+
+		switch {
+		case opts.RecreateRows:
+			err = store.recreateRow(checksum, repo, opts.DryRun)
+			report.RecreatedRows = appendRepair(&report, report.RecreatedRows, checksum, err)
+		case opts.QuarantineFiles:
+			err = store.quarantine(checksum, opts.DryRun)
+			report.Quarantined = appendRepair(&report, report.Quarantined, checksum, err)
+		}
+	}
+
+	stored := make(map[string]bool, len(files))
+	for _, checksum := range files {
+		stored[checksum] = true
+	}
+	for _, record := range rows {
+		if stored[record.Checksum] {
+			continue
+		}
+		report.RowsWithoutFiles = append(report.RowsWithoutFiles, record.Filename)
+
+		if opts.DeleteRows {
+			if !opts.DryRun {
+				_, err = repo.DeleteInfo(record)
+			}
+			report.DeletedRows = appendRepair(&report, report.DeletedRows, record.Filename, err)
+		}
+	}
+
+	return report, nil
+}
+
+// appendRepair adds name to the list of a repair if it succeeded, otherwise records the error.
+func appendRepair(report *FsckReport, done []string, name string, err error) []string {
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", name, err))
+		return done
+	}
+
+	return append(done, name)
+}
+
+// recreateRow saves a record for content that has none, built from what the content itself
+// tells. The original filename is lost, so the image is named after its checksum.
+func (store *ImageStore) recreateRow(checksum string, repo ImageDB, dryRun bool) error {
+	reader, info, err := store.blobs.Get(contentKey(checksum), 0, 0)
+	if err != nil {
+		return blobError("cannot open image", err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	head := &headWriter{}
+	_, err = io.Copy(io.MultiWriter(hash, head), reader)
+	if err != nil {
+		return blobError("cannot read image", err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum {
+		return fmt.Errorf("content hashes to %s, not its name", sum)
+	}
+	if dryRun {
+		return nil
+	}
+
+	imageID, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("cannot generate image id: %w", err)
+	}
+	return repo.SaveNewInfo(ImagesInfo{
+		ImageId:     imageID.String(),
+		Filename:    checksum,
+		CreatedAt:   info.ModTime,
+		ChangedAt:   info.ModTime,
+		Size:        info.Size,
+		ContentType: http.DetectContentType(head.data),
+		Checksum:    checksum,
+	})
+}
+
+// quarantine moves content without a record under quarantineFolder.
+func (store *ImageStore) quarantine(checksum string, dryRun bool) error {
+	if dryRun {
+		return nil
+	}
+
+	target := quarantineFolder + "/" + checksum
+	err := store.blobs.Copy(contentKey(checksum), target)
+	if err != nil {
+		return blobError("cannot copy image to quarantine", err)
+	}
+	err = store.blobs.Delete(contentKey(checksum))
+	if err != nil {
+		return blobError("cannot remove quarantined image", err)
+	}
+
+	return nil
+}
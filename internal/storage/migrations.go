@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// migrations holds the schema changes, one file per version named <version>_<name>.sql.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migration is one schema change read from migrations.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations returns the embedded migrations ordered by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []migration
+	for _, entry := range entries {
+		number, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.sql", entry.Name())
+		}
+		if len(loaded) > 0 && loaded[len(loaded)-1].version == version {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", loaded[len(loaded)-1].name, name, version)
+		}
+
+		data, err := migrations.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, migration{version: version, name: name, sql: string(data)})
+	}
+
+	return loaded, nil
+}
+
+// migrateSchema applies the migrations the DB has not seen yet, each in a transaction of its
+// own. The applied versions are kept in schema_migrations, which is locked while a migration
+// runs, so servers starting together apply each migration once.
+func migrateSchema(db *sql.DB) error {
+	all, err := loadMigrations()
+	if err != nil {
+		return fmt.Errorf("unable to read migrations: %w", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now())`)
+	if err != nil {
+		return fmt.Errorf("unable to create migrations table: %w", dbError(err))
+	}
+
+	for _, m := range all {
+		err = applyMigration(db, m)
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return dbError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`LOCK TABLE schema_migrations IN EXCLUSIVE MODE`)
+	if err != nil {
+		return dbError(err)
+	}
+	var applied bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, m.version).Scan(&applied)
+	if err != nil || applied {
+		return dbError(err)
+	}
+
+	_, err = tx.Exec(m.sql)
+	if err != nil {
+		return dbError(err)
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name)
+	if err != nil {
+		return dbError(err)
+	}
+
+	return dbError(tx.Commit())
+}
@@ -0,0 +1,63 @@
+-- The schema as it was built on every start before migrations were versioned. Each statement
+-- is idempotent, so databases created back then are adopted as they are.
+
+CREATE TABLE IF NOT EXISTS images (
+	id SERIAL PRIMARY KEY,
+	filename VARCHAR UNIQUE,
+	created_at VARCHAR,
+	changed_at VARCHAR,
+	image_id VARCHAR);
+ALTER TABLE images ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	upload_id VARCHAR PRIMARY KEY,
+	filename VARCHAR NOT NULL,
+	size BIGINT NOT NULL,
+	content_type VARCHAR NOT NULL DEFAULT '',
+	sha256 VARCHAR NOT NULL DEFAULT '',
+	tags TEXT[] NOT NULL DEFAULT '{}',
+	received BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL);
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS content_type VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS checksum VARCHAR NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS blobs (
+	checksum VARCHAR PRIMARY KEY,
+	size BIGINT NOT NULL,
+	refs INT NOT NULL DEFAULT 0);
+-- Counts the references of images saved before content was shared; once a checksum is
+-- known its count is kept up to date by the statements changing the images.
+INSERT INTO blobs (checksum, size, refs)
+	SELECT checksum, MAX(size), COUNT(*) FROM images WHERE checksum <> '' GROUP BY checksum
+	ON CONFLICT (checksum) DO NOTHING;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+CREATE TABLE IF NOT EXISTS image_versions (
+	image_id VARCHAR NOT NULL,
+	version INT NOT NULL,
+	size BIGINT NOT NULL,
+	content_type VARCHAR NOT NULL,
+	checksum VARCHAR NOT NULL,
+	created_at VARCHAR NOT NULL,
+	replaced_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (image_id, version));
+
+-- Trashed images keep their filename, so it only has to be unique among the live ones.
+ALTER TABLE images ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_filename_key;
+CREATE UNIQUE INDEX IF NOT EXISTS images_live_filename ON images (filename) WHERE deleted_at IS NULL;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS namespace VARCHAR NOT NULL DEFAULT 'default';
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS namespace VARCHAR NOT NULL DEFAULT 'default';
+CREATE TABLE IF NOT EXISTS namespace_usage (
+	namespace VARCHAR PRIMARY KEY,
+	bytes BIGINT NOT NULL DEFAULT 0,
+	files BIGINT NOT NULL DEFAULT 0);
+-- Like the content references, the usage is counted once from the images saved before
+-- it was kept and then updated by the statements changing the images.
+INSERT INTO namespace_usage (namespace, bytes, files)
+	SELECT namespace, SUM(size), COUNT(*) FROM images GROUP BY namespace
+	ON CONFLICT (namespace) DO NOTHING;
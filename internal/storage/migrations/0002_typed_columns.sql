@@ -0,0 +1,50 @@
+-- Timestamps were stored as time.RFC850 strings like "Monday, 02-Jan-06 15:04:05 MST", in
+-- the time zone abbreviated at their end. A zone Postgres does not know is taken to be the
+-- one of the session, as the sorting of the old strings did.
+CREATE FUNCTION pg_temp.rfc850(value VARCHAR) RETURNS TIMESTAMPTZ LANGUAGE SQL AS $$
+	SELECT CASE
+		WHEN value IS NULL OR value = '' THEN NULL
+		WHEN EXISTS (SELECT 1 FROM pg_timezone_abbrevs WHERE abbrev = split_part(value, ' ', 4)) THEN
+			to_timestamp(split_part(value, ' ', 2) || ' ' || split_part(value, ' ', 3), 'DD-Mon-YY HH24:MI:SS')::timestamp
+				AT TIME ZONE split_part(value, ' ', 4)
+		ELSE to_timestamp(split_part(value, ' ', 2) || ' ' || split_part(value, ' ', 3), 'DD-Mon-YY HH24:MI:SS')
+	END
+$$;
+
+ALTER TABLE images
+	ALTER COLUMN created_at TYPE TIMESTAMPTZ USING pg_temp.rfc850(created_at),
+	ALTER COLUMN changed_at TYPE TIMESTAMPTZ USING pg_temp.rfc850(changed_at);
+UPDATE images SET created_at = COALESCE(created_at, changed_at, now()), changed_at = COALESCE(changed_at, created_at, now())
+	WHERE created_at IS NULL OR changed_at IS NULL;
+ALTER TABLE images
+	ALTER COLUMN created_at SET NOT NULL,
+	ALTER COLUMN created_at SET DEFAULT now(),
+	ALTER COLUMN changed_at SET NOT NULL,
+	ALTER COLUMN changed_at SET DEFAULT now();
+
+ALTER TABLE image_versions
+	ALTER COLUMN created_at TYPE TIMESTAMPTZ USING COALESCE(pg_temp.rfc850(created_at), replaced_at);
+
+-- Every image gets an image_id of its own, so it can be the key of the image. Those without a
+-- UUID get a new one, and so do all but the first of the images sharing one. The versions of
+-- an image_id follow the first image that had it.
+CREATE TEMP TABLE image_id_map ON COMMIT DROP AS
+	SELECT id, image_id AS old_id, gen_random_uuid()::text AS new_id,
+		id = MIN(id) OVER (PARTITION BY image_id) AS kept
+	FROM images;
+DELETE FROM image_id_map
+	WHERE kept AND old_id ~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$';
+UPDATE image_versions SET image_id = m.new_id
+	FROM image_id_map m WHERE m.kept AND image_versions.image_id = m.old_id;
+UPDATE images SET image_id = m.new_id
+	FROM image_id_map m WHERE images.id = m.id;
+-- Versions left without a UUID belong to no image and could not be restored anyway.
+DELETE FROM image_versions
+	WHERE image_id !~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$';
+ALTER TABLE images
+	ALTER COLUMN image_id TYPE UUID USING image_id::uuid,
+	ALTER COLUMN image_id SET NOT NULL,
+	ADD CONSTRAINT images_image_id_key UNIQUE (image_id);
+ALTER TABLE image_versions ALTER COLUMN image_id TYPE UUID USING image_id::uuid;
+
+ALTER TABLE images ALTER COLUMN filename SET NOT NULL;
@@ -2,11 +2,16 @@ package storage
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -14,6 +19,27 @@ type ImageDB interface {
 	SaveNewInfo(imageInfo ImagesInfo) error
 	UpdateInfo(imageInfo ImagesInfo) (string, error)
 	GetAllInfo(files []string) ([]ImagesInfo, error)
+	ListInfo(opts ListOptions) ([]ImagesInfo, error)
+	DeleteInfo(imageInfo ImagesInfo) (ImagesInfo, error)
+	TrashInfo(imageInfo ImagesInfo) (ImagesInfo, error)
+	ListTrash(opts ListOptions) ([]ImagesInfo, error)
+	RestoreInfo(imageInfo ImagesInfo) (ImagesInfo, error)
+	PurgeTrash(namespace string, before time.Time) (int, error)
+	GetInfo(imageInfo ImagesInfo) (ImagesInfo, error)
+	RenameInfo(imageInfo ImagesInfo, newFilename string, overwrite bool) (ImagesInfo, error)
+	CopyInfo(newImage ImagesInfo, overwrite bool) error
+	ListVersions(imageID string) ([]ImageVersion, error)
+	GetVersion(imageID string, version int) (ImageVersion, error)
+	DeleteOldVersions(keepLast int, before time.Time) (int, error)
+	ContentRefs(checksums []string) (map[string]int, error)
+	DeleteUnreferencedContent(checksums []string) error
+	GetUsage(namespace string) (Usage, error)
+	TotalUsage() (Usage, error)
+	SaveUploadSession(session UploadSession) error
+	GetUploadSession(uploadID string) (UploadSession, error)
+	UpdateUploadSession(uploadID string, received int64) error
+	DeleteUploadSession(uploadID string) error
+	DeleteExpiredUploadSessions(before time.Time) ([]string, error)
 	Close()
 }
 
@@ -26,60 +52,233 @@ func NewDB(dbPath string) (ImageDB, error) {
 	if err != nil {
 		return nil, err
 	}
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS images (
-			id SERIAL PRIMARY KEY,
-			filename VARCHAR UNIQUE,
-			created_at VARCHAR, 
-			changed_at VARCHAR,
-			image_id VARCHAR)
-		`)
+	err = migrateSchema(db)
 	if err != nil {
-		return nil, fmt.Errorf("unable to CREATE TABLE in DB: %w", err)
+		return nil, fmt.Errorf("unable to prepare DB schema: %w", err)
 	}
 
 	return &DataBase{DB: db}, nil
 }
 
 func (d *DataBase) SaveNewInfo(imageInfo ImagesInfo) error {
-	query := `INSERT INTO images (image_id, filename, created_at, changed_at) VALUES ($1, $2, $3, $4)`
-	_, err := d.DB.Exec(query, imageInfo.ImageId, imageInfo.Filename, imageInfo.CreatedAt, imageInfo.ChangedAt)
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("[Image DB] unable to begin transaction: %w", dbError(err))
+	}
+	defer tx.Rollback()
+
+	imageInfo.Namespace = namespaceOrDefault(imageInfo.Namespace)
+	query := `INSERT INTO images (image_id, filename, created_at, changed_at, tags, size, content_type, checksum, namespace)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = tx.Exec(query, imageInfo.ImageId, imageInfo.Filename, imageInfo.CreatedAt, imageInfo.ChangedAt,
+		tagsOrEmpty(imageInfo.Tags), imageInfo.Size, imageInfo.ContentType, imageInfo.Checksum, imageInfo.Namespace)
 	if err != nil && strings.Contains(err.Error(), pgerrcode.UniqueViolation) {
-		query := `UPDATE images SET changed_at = $1 WHERE filename = $2`
-		_, err := d.DB.Exec(query, imageInfo.ChangedAt, imageInfo.Filename)
+		tx.Rollback()
+		_, err := d.UpdateInfo(imageInfo)
 		if err != nil {
-			return fmt.Errorf("[Image DB] Error while updating new image info (SaveNewInfo): %w", err)
+			return fmt.Errorf("[Image DB] Error while updating new image info (SaveNewInfo): %w", dbError(err))
 		}
 
 		return nil
 	}
 	if err != nil {
-		return fmt.Errorf("[Image DB] Error while SAVING new image info: %w", err)
+		return fmt.Errorf("[Image DB] Error while SAVING new image info: %w", dbError(err))
+	}
+
+	err = addRef(tx, imageInfo.Checksum, imageInfo.Size)
+	if err != nil {
+		return err
+	}
+	err = addUsage(tx, imageInfo.Namespace, imageInfo.Size, 1)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[Image DB] unable to commit new image info: %w", dbError(err))
 	}
 
 	return nil
 }
 
+// UpdateInfo replaces the record holding the Filename of imageInfo. When the content changes
+// the previous one is kept as a version of the image, which takes over its reference.
 func (d *DataBase) UpdateInfo(imageInfo ImagesInfo) (string, error) {
-	query := `UPDATE images SET changed_at = $1 WHERE filename = $2 RETURNING image_id`
-	row := d.DB.QueryRow(query, imageInfo.ChangedAt, imageInfo.Filename)
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return "", fmt.Errorf("[Image DB] unable to begin transaction: %w", dbError(err))
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`SELECT %s FROM images WHERE filename = $1 AND deleted_at IS NULL FOR UPDATE`, imageColumns)
+	previous, err := scanImage(tx.QueryRow(query, imageInfo.Filename), pgtype.NewMap())
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("[Image DB] key not found while updating DB: %w", dbError(err))
+	}
+	if err != nil {
+		return "", fmt.Errorf("[Image DB] unable to read image info: %w", dbError(err))
+	}
+	// Records saved before checksums were kept have no content worth a version.
+	archive := previous.Checksum != "" && previous.Checksum != imageInfo.Checksum
+	if archive {
+		_, err = tx.Exec(`INSERT INTO image_versions (image_id, version, size, content_type, checksum, created_at, replaced_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`, previous.ImageId, previous.Version, previous.Size, previous.ContentType,
+			previous.Checksum, previous.ChangedAt, time.Now())
+		if err != nil {
+			return "", fmt.Errorf("[Image DB] Error while keeping image version: %w", dbError(err))
+		}
+		imageInfo.Version = previous.Version + 1
+	} else {
+		imageInfo.Version = previous.Version
+	}
+
+	query = `UPDATE images SET changed_at = $1, tags = $2, size = $3, content_type = $4, checksum = $5, version = $6
+		WHERE filename = $7 AND deleted_at IS NULL RETURNING image_id`
+	row := tx.QueryRow(query, imageInfo.ChangedAt, tagsOrEmpty(imageInfo.Tags), imageInfo.Size, imageInfo.ContentType,
+		imageInfo.Checksum, imageInfo.Version, imageInfo.Filename)
 	var imageID string
 	if err := row.Scan(&imageID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", fmt.Errorf("[Image DB] key not found while updating DB: %w", err)
+		return "", fmt.Errorf("[Image DB] I unable to Scan imageID from DB: %w", dbError(err))
+	}
+
+	if previous.Checksum != imageInfo.Checksum {
+		if !archive {
+			err = dropRef(tx, previous.Checksum)
+			if err != nil {
+				return "", err
+			}
+		}
+		err = addRef(tx, imageInfo.Checksum, imageInfo.Size)
+		if err != nil {
+			return "", err
 		}
-		return "", fmt.Errorf("[Image DB] I unable to Scan imageID from DB: %w", err)
+	}
+	err = addUsage(tx, previous.Namespace, imageInfo.Size-previous.Size, 0)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", fmt.Errorf("[Image DB] unable to commit image info update: %w", dbError(err))
 	}
 
 	return imageID, nil
 }
 
+// addRef counts one more image referring to the content with checksum, registering the
+// content if it is new.
+func addRef(tx *sql.Tx, checksum string, size int64) error {
+	if checksum == "" {
+		return nil
+	}
+
+	_, err := tx.Exec(`INSERT INTO blobs (checksum, size, refs) VALUES ($1, $2, 1)
+		ON CONFLICT (checksum) DO UPDATE SET refs = blobs.refs + 1`, checksum, size)
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while counting content reference: %w", dbError(err))
+	}
+
+	return nil
+}
+
+// dropRef counts one image less referring to the content with checksum. Content nothing
+// refers to keeps its entry until garbage collection removes it together with the blob.
+func dropRef(tx *sql.Tx, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+
+	_, err := tx.Exec(`UPDATE blobs SET refs = refs - 1 WHERE checksum = $1 AND refs > 0`, checksum)
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while releasing content reference: %w", dbError(err))
+	}
+
+	return nil
+}
+
+// addUsage adds bytes and files to the usage of namespace.
+func addUsage(tx *sql.Tx, namespace string, bytes, files int64) error {
+	if bytes == 0 && files == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`INSERT INTO namespace_usage (namespace, bytes, files) VALUES ($1, $2, $3)
+		ON CONFLICT (namespace) DO UPDATE SET bytes = namespace_usage.bytes + $2, files = namespace_usage.files + $3`,
+		namespace, bytes, files)
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while counting namespace usage: %w", dbError(err))
+	}
+
+	return nil
+}
+
+// GetUsage returns the usage of namespace; a namespace without images uses nothing.
+func (d *DataBase) GetUsage(namespace string) (Usage, error) {
+	var usage Usage
+	err := d.DB.QueryRow(`SELECT bytes, files FROM namespace_usage WHERE namespace = $1`, namespace).
+		Scan(&usage.Bytes, &usage.Files)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Usage{}, nil
+	}
+	if err != nil {
+		return Usage{}, fmt.Errorf("[Image DB] unable to read namespace usage: %w", dbError(err))
+	}
+
+	return usage, nil
+}
+
+// TotalUsage returns the usage of all namespaces together.
+func (d *DataBase) TotalUsage() (Usage, error) {
+	var usage Usage
+	err := d.DB.QueryRow(`SELECT COALESCE(SUM(bytes), 0), COALESCE(SUM(files), 0) FROM namespace_usage`).
+		Scan(&usage.Bytes, &usage.Files)
+	if err != nil {
+		return Usage{}, fmt.Errorf("[Image DB] unable to read storage usage: %w", dbError(err))
+	}
+
+	return usage, nil
+}
+
+// ContentRefs returns how many images refer to each of checksums. Content the DB does not
+// know is left out of the map.
+func (d *DataBase) ContentRefs(checksums []string) (map[string]int, error) {
+	rows, err := d.DB.Query(`SELECT checksum, refs FROM blobs WHERE checksum = ANY ($1)`, checksums)
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] unable to read content references: %w", dbError(err))
+	}
+	defer rows.Close()
+
+	refs := make(map[string]int, len(checksums))
+	for rows.Next() {
+		var checksum string
+		var n int
+		if err := rows.Scan(&checksum, &n); err != nil {
+			return nil, err
+		}
+		refs[checksum] = n
+	}
+
+	return refs, rows.Err()
+}
+
+// DeleteUnreferencedContent forgets those of checksums that no image refers to.
+func (d *DataBase) DeleteUnreferencedContent(checksums []string) error {
+	_, err := d.DB.Exec(`DELETE FROM blobs WHERE checksum = ANY ($1) AND refs <= 0`, checksums)
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while deleting unreferenced content: %w", dbError(err))
+	}
+
+	return nil
+}
+
 func (d *DataBase) GetAllInfo(files []string) ([]ImagesInfo, error) {
-	query2 := `SELECT filename, created_at, changed_at FROM images WHERE filename = ANY ($1)`
+	query2 := `SELECT filename, created_at, changed_at FROM images WHERE filename = ANY ($1) AND deleted_at IS NULL`
 
 	rows, err := d.DB.Query(query2, files)
 	if err != nil {
-		return nil, fmt.Errorf("unable to return records from DB: %w", err)
+		return nil, fmt.Errorf("unable to return records from DB: %w", dbError(err))
 	}
 	defer rows.Close()
 
@@ -101,6 +300,575 @@ func (d *DataBase) GetAllInfo(files []string) ([]ImagesInfo, error) {
 	return records, nil
 }
 
+// dbError marks err with ErrUnavailable when the database could not be reached,
+// so callers can tell an outage from a failed query.
+func dbError(err error) error {
+	if errors.Is(err, ErrUnavailable) {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || pgconn.Timeout(err) || errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	return err
+}
+
+// tagsOrEmpty keeps a nil slice from being stored as NULL in the NOT NULL tags column.
+func tagsOrEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// DeleteInfo removes the live record matching the ImageId of imageInfo or, if it is empty, its
+// Filename, for good.
+func (d *DataBase) DeleteInfo(imageInfo ImagesInfo) (ImagesInfo, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to begin transaction: %w", dbError(err))
+	}
+	defer tx.Rollback()
+
+	where, key := imageKey(imageInfo)
+	query := fmt.Sprintf(`DELETE FROM images WHERE %s AND deleted_at IS NULL RETURNING %s`, where, imageColumns)
+
+	deleted, err := scanImage(tx.QueryRow(query, key), pgtype.NewMap())
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] %s: %w", key, ErrImgNotFound)
+	}
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] Error while DELETING image info: %w", dbError(err))
+	}
+
+	err = dropRef(tx, deleted.Checksum)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	err = addUsage(tx, deleted.Namespace, -deleted.Size, -1)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	err = deleteVersions(tx, deleted.ImageId)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to commit delete: %w", dbError(err))
+	}
+
+	return deleted, nil
+}
+
+// TrashInfo moves the live record matching the ImageId of imageInfo or, if it is empty, its
+// Filename to the trash. A trashed image keeps its content and versions until it is purged.
+func (d *DataBase) TrashInfo(imageInfo ImagesInfo) (ImagesInfo, error) {
+	where, key := imageKey(imageInfo)
+	query := fmt.Sprintf(`UPDATE images SET deleted_at = $2 WHERE %s AND deleted_at IS NULL RETURNING %s`,
+		where, imageColumns)
+
+	trashed, err := scanImage(d.DB.QueryRow(query, key, time.Now()), pgtype.NewMap())
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] %s: %w", key, ErrImgNotFound)
+	}
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] Error while trashing image info: %w", dbError(err))
+	}
+
+	return trashed, nil
+}
+
+// ListTrash returns a page of the trashed records, most recently deleted first. OrderBy and
+// Descending of opts are ignored.
+func (d *DataBase) ListTrash(opts ListOptions) ([]ImagesInfo, error) {
+	query := fmt.Sprintf(`SELECT %s FROM images WHERE filename LIKE $1 ESCAPE '\' AND deleted_at IS NOT NULL
+		AND ($4 = '' OR namespace = $4) ORDER BY deleted_at DESC, filename LIMIT $2 OFFSET $3`, imageColumns)
+
+	rows, err := d.DB.Query(query, likePrefix(opts.Prefix), opts.Limit, opts.Offset, opts.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] unable to list trash: %w", dbError(err))
+	}
+	defer rows.Close()
+
+	types := pgtype.NewMap()
+	records := []ImagesInfo{}
+	for rows.Next() {
+		record, err := scanImage(rows, types)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// RestoreInfo takes the trashed record matching the ImageId of imageInfo or, if it is empty,
+// the one with its Filename deleted last out of the trash. ErrImgExists is returned when a
+// live record holds the filename. With a Namespace in imageInfo, records of that namespace
+// come first and ErrOtherNamespace is returned for a record of another.
+func (d *DataBase) RestoreInfo(imageInfo ImagesInfo) (ImagesInfo, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to begin transaction: %w", dbError(err))
+	}
+	defer tx.Rollback()
+
+	where, key := imageKey(imageInfo)
+	query := fmt.Sprintf(`SELECT id, namespace FROM images WHERE %s AND deleted_at IS NOT NULL
+		ORDER BY namespace = $2 DESC, deleted_at DESC LIMIT 1 FOR UPDATE`, where)
+	var id int64
+	var namespace string
+	err = tx.QueryRow(query, key, imageInfo.Namespace).Scan(&id, &namespace)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] %s: %w", key, ErrImgNotFound)
+	}
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to read trashed image info: %w", dbError(err))
+	}
+	if imageInfo.Namespace != "" && namespace != imageInfo.Namespace {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] %s is kept in namespace %q: %w", key, namespace, ErrOtherNamespace)
+	}
+
+	query = fmt.Sprintf(`UPDATE images SET deleted_at = NULL WHERE id = $1 RETURNING %s`, imageColumns)
+	restored, err := scanImage(tx.QueryRow(query, id), pgtype.NewMap())
+	if err != nil && strings.Contains(err.Error(), pgerrcode.UniqueViolation) {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] %s: %w", key, ErrImgExists)
+	}
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] Error while restoring image info: %w", dbError(err))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to commit restore: %w", dbError(err))
+	}
+
+	return restored, nil
+}
+
+// PurgeTrash removes the records of namespace, or of every namespace if it is empty, trashed
+// before the given time for good, together with their versions, and returns how many it
+// removed. Their content is left to garbage collection.
+func (d *DataBase) PurgeTrash(namespace string, before time.Time) (int, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("[Image DB] unable to begin transaction: %w", dbError(err))
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`DELETE FROM images WHERE deleted_at < $1 AND ($2 = '' OR namespace = $2)
+		RETURNING image_id, checksum, size, namespace`, before, namespace)
+	if err != nil {
+		return 0, fmt.Errorf("[Image DB] Error while purging trash: %w", dbError(err))
+	}
+	var purged []ImagesInfo
+	for rows.Next() {
+		var record ImagesInfo
+		if err := rows.Scan(&record.ImageId, &record.Checksum, &record.Size, &record.Namespace); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		purged = append(purged, record)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("[Image DB] unable to read purged images: %w", dbError(err))
+	}
+
+	for _, record := range purged {
+		err = dropRef(tx, record.Checksum)
+		if err != nil {
+			return 0, err
+		}
+		err = addUsage(tx, record.Namespace, -record.Size, -1)
+		if err != nil {
+			return 0, err
+		}
+		err = deleteVersions(tx, record.ImageId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("[Image DB] unable to commit trash purge: %w", dbError(err))
+	}
+
+	return len(purged), nil
+}
+
+func (d *DataBase) ListInfo(opts ListOptions) ([]ImagesInfo, error) {
+	orderBy := "filename"
+	switch opts.OrderBy {
+	case SortByCreated:
+		orderBy = "created_at"
+	case SortByChanged:
+		orderBy = "changed_at"
+	}
+	direction := "ASC"
+	if opts.Descending {
+		direction = "DESC"
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM images WHERE filename LIKE $1 ESCAPE '\' AND deleted_at IS NULL
+		ORDER BY %s %s, filename %s LIMIT $2 OFFSET $3`, imageColumns, orderBy, direction, direction)
+
+	rows, err := d.DB.Query(query, likePrefix(opts.Prefix), opts.Limit, opts.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] unable to list images: %w", dbError(err))
+	}
+	defer rows.Close()
+
+	types := pgtype.NewMap()
+	records := []ImagesInfo{}
+	for rows.Next() {
+		record, err := scanImage(rows, types)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// imageColumns lists the columns scanImage expects, in order.
+const imageColumns = `image_id, filename, created_at, changed_at, tags, size, content_type, checksum, version, deleted_at,
+	namespace`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanImage(row rowScanner, types *pgtype.Map) (ImagesInfo, error) {
+	var record ImagesInfo
+	var deletedAt sql.NullTime
+	err := row.Scan(&record.ImageId, &record.Filename, &record.CreatedAt, &record.ChangedAt,
+		types.SQLScanner(&record.Tags), &record.Size, &record.ContentType, &record.Checksum, &record.Version, &deletedAt,
+		&record.Namespace)
+	record.DeletedAt = deletedAt.Time
+
+	return record, err
+}
+
+// imageKey returns the WHERE clause and argument selecting the record by the ImageId of
+// imageInfo or, if it is empty, by its Filename.
+func imageKey(imageInfo ImagesInfo) (string, string) {
+	if imageInfo.ImageId != "" {
+		return "image_id = $1", imageInfo.ImageId
+	}
+	return "filename = $1", imageInfo.Filename
+}
+
+// GetInfo returns the live record matching the ImageId of imageInfo or, if it is empty, its Filename.
+func (d *DataBase) GetInfo(imageInfo ImagesInfo) (ImagesInfo, error) {
+	where, key := imageKey(imageInfo)
+	query := fmt.Sprintf(`SELECT %s FROM images WHERE %s AND deleted_at IS NULL`, imageColumns, where)
+
+	record, err := scanImage(d.DB.QueryRow(query, key), pgtype.NewMap())
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] %s: %w", key, ErrImgNotFound)
+	}
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to read image info: %w", dbError(err))
+	}
+
+	return record, nil
+}
+
+// RenameInfo moves the record of imageInfo to newFilename, keeping its image_id and setting
+// changed_at from imageInfo. With overwrite a record already holding newFilename is replaced,
+// otherwise ErrImgExists is returned.
+func (d *DataBase) RenameInfo(imageInfo ImagesInfo, newFilename string, overwrite bool) (ImagesInfo, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to begin transaction: %w", dbError(err))
+	}
+	defer tx.Rollback()
+
+	if overwrite {
+		err = replaceInfo(tx, newFilename)
+		if err != nil {
+			return ImagesInfo{}, err
+		}
+	}
+
+	where, key := imageKey(imageInfo)
+	query := fmt.Sprintf(`UPDATE images SET filename = $2, changed_at = $3 WHERE %s AND deleted_at IS NULL RETURNING %s`,
+		where, imageColumns)
+	renamed, err := scanImage(tx.QueryRow(query, key, newFilename, imageInfo.ChangedAt), pgtype.NewMap())
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] %s: %w", key, ErrImgNotFound)
+	}
+	if err != nil && strings.Contains(err.Error(), pgerrcode.UniqueViolation) {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] %s: %w", newFilename, ErrImgExists)
+	}
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] Error while renaming image info: %w", dbError(err))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("[Image DB] unable to commit rename: %w", dbError(err))
+	}
+
+	return renamed, nil
+}
+
+// CopyInfo inserts newImage as a new record. With overwrite a record already holding its
+// filename is replaced, otherwise ErrImgExists is returned.
+func (d *DataBase) CopyInfo(newImage ImagesInfo, overwrite bool) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("[Image DB] unable to begin transaction: %w", dbError(err))
+	}
+	defer tx.Rollback()
+
+	if overwrite {
+		err = replaceInfo(tx, newImage.Filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	newImage.Namespace = namespaceOrDefault(newImage.Namespace)
+	query := `INSERT INTO images (image_id, filename, created_at, changed_at, tags, size, content_type, checksum, namespace)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = tx.Exec(query, newImage.ImageId, newImage.Filename, newImage.CreatedAt, newImage.ChangedAt,
+		tagsOrEmpty(newImage.Tags), newImage.Size, newImage.ContentType, newImage.Checksum, newImage.Namespace)
+	if err != nil && strings.Contains(err.Error(), pgerrcode.UniqueViolation) {
+		return fmt.Errorf("[Image DB] %s: %w", newImage.Filename, ErrImgExists)
+	}
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while copying image info: %w", dbError(err))
+	}
+
+	err = addRef(tx, newImage.Checksum, newImage.Size)
+	if err != nil {
+		return err
+	}
+	err = addUsage(tx, newImage.Namespace, newImage.Size, 1)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[Image DB] unable to commit copy: %w", dbError(err))
+	}
+
+	return nil
+}
+
+// replaceInfo moves the record holding filename, if there is one, to the trash to make room
+// for another.
+func replaceInfo(tx *sql.Tx, filename string) error {
+	_, err := tx.Exec(`UPDATE images SET deleted_at = $2 WHERE filename = $1 AND deleted_at IS NULL`, filename, time.Now())
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while replacing image info: %w", dbError(err))
+	}
+
+	return nil
+}
+
+// deleteVersions removes the kept versions of an image that is going away.
+func deleteVersions(tx *sql.Tx, imageID string) error {
+	rows, err := tx.Query(`DELETE FROM image_versions WHERE image_id = $1 RETURNING checksum`, imageID)
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while deleting image versions: %w", dbError(err))
+	}
+
+	_, err = dropRefs(tx, rows)
+	return err
+}
+
+// dropRefs releases the content of every checksum in rows, closing them, and returns how
+// many there were.
+func dropRefs(tx *sql.Tx, rows *sql.Rows) (int, error) {
+	var checksums []string
+	for rows.Next() {
+		var checksum string
+		if err := rows.Scan(&checksum); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		checksums = append(checksums, checksum)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("[Image DB] unable to read released content: %w", dbError(err))
+	}
+
+	for _, checksum := range checksums {
+		if err := dropRef(tx, checksum); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(checksums), nil
+}
+
+const versionColumns = `image_id, version, size, content_type, checksum, created_at, replaced_at`
+
+func scanVersion(row rowScanner) (ImageVersion, error) {
+	var version ImageVersion
+	err := row.Scan(&version.ImageId, &version.Version, &version.Size, &version.ContentType, &version.Checksum,
+		&version.CreatedAt, &version.ReplacedAt)
+
+	return version, err
+}
+
+// ListVersions returns the kept earlier versions of an image, newest first.
+func (d *DataBase) ListVersions(imageID string) ([]ImageVersion, error) {
+	query := fmt.Sprintf(`SELECT %s FROM image_versions WHERE image_id = $1 ORDER BY version DESC`, versionColumns)
+	rows, err := d.DB.Query(query, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] unable to list image versions: %w", dbError(err))
+	}
+	defer rows.Close()
+
+	versions := []ImageVersion{}
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+func (d *DataBase) GetVersion(imageID string, version int) (ImageVersion, error) {
+	query := fmt.Sprintf(`SELECT %s FROM image_versions WHERE image_id = $1 AND version = $2`, versionColumns)
+	found, err := scanVersion(d.DB.QueryRow(query, imageID, version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImageVersion{}, fmt.Errorf("[Image DB] version %d of %s: %w", version, imageID, ErrVersionNotFound)
+	}
+	if err != nil {
+		return ImageVersion{}, fmt.Errorf("[Image DB] unable to read image version: %w", dbError(err))
+	}
+
+	return found, nil
+}
+
+// DeleteOldVersions removes the kept versions that have more than keepLast newer kept versions
+// of the same image or were replaced before the given time, and returns how many it removed.
+// A zero keepLast or before disables that rule.
+func (d *DataBase) DeleteOldVersions(keepLast int, before time.Time) (int, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("[Image DB] unable to begin transaction: %w", dbError(err))
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM image_versions v USING (
+			SELECT image_id, version, row_number() OVER (PARTITION BY image_id ORDER BY version DESC) AS newer
+			FROM image_versions) r
+		WHERE v.image_id = r.image_id AND v.version = r.version
+			AND (($1 > 0 AND r.newer > $1) OR v.replaced_at < $2)
+		RETURNING v.checksum`
+	rows, err := tx.Query(query, keepLast, before)
+	if err != nil {
+		return 0, fmt.Errorf("[Image DB] Error while deleting old image versions: %w", dbError(err))
+	}
+
+	n, err := dropRefs(tx, rows)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("[Image DB] unable to commit version cleanup: %w", dbError(err))
+	}
+
+	return n, nil
+}
+
+// likePrefix turns prefix into a LIKE pattern that matches it literally.
+func likePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(prefix) + "%"
+}
+
+func (d *DataBase) SaveUploadSession(session UploadSession) error {
+	query := `INSERT INTO upload_sessions (upload_id, filename, size, content_type, sha256, tags, received, created_at, updated_at, namespace)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, $9)`
+	_, err := d.DB.Exec(query, session.UploadID, session.Filename, session.Size, session.ContentType, session.Checksum,
+		tagsOrEmpty(session.Tags), session.Received, session.CreatedAt, namespaceOrDefault(session.Namespace))
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while SAVING upload session: %w", dbError(err))
+	}
+
+	return nil
+}
+
+func (d *DataBase) GetUploadSession(uploadID string) (UploadSession, error) {
+	query := `SELECT upload_id, filename, size, content_type, sha256, tags, received, created_at, updated_at, namespace
+		FROM upload_sessions WHERE upload_id = $1`
+	var session UploadSession
+	err := d.DB.QueryRow(query, uploadID).Scan(&session.UploadID, &session.Filename, &session.Size, &session.ContentType,
+		&session.Checksum, pgtype.NewMap().SQLScanner(&session.Tags), &session.Received, &session.CreatedAt, &session.UpdatedAt,
+		&session.Namespace)
+	if errors.Is(err, sql.ErrNoRows) {
+		return UploadSession{}, fmt.Errorf("[Image DB] upload session %s: %w", uploadID, ErrUploadNotFound)
+	}
+	if err != nil {
+		return UploadSession{}, fmt.Errorf("[Image DB] unable to read upload session: %w", dbError(err))
+	}
+
+	return session, nil
+}
+
+func (d *DataBase) UpdateUploadSession(uploadID string, received int64) error {
+	query := `UPDATE upload_sessions SET received = $1, updated_at = $2 WHERE upload_id = $3`
+	res, err := d.DB.Exec(query, received, time.Now(), uploadID)
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while updating upload session: %w", dbError(err))
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("[Image DB] upload session %s: %w", uploadID, ErrUploadNotFound)
+	}
+
+	return nil
+}
+
+func (d *DataBase) DeleteUploadSession(uploadID string) error {
+	_, err := d.DB.Exec(`DELETE FROM upload_sessions WHERE upload_id = $1`, uploadID)
+	if err != nil {
+		return fmt.Errorf("[Image DB] Error while deleting upload session: %w", dbError(err))
+	}
+
+	return nil
+}
+
+// DeleteExpiredUploadSessions removes sessions not touched since before and returns their IDs.
+func (d *DataBase) DeleteExpiredUploadSessions(before time.Time) ([]string, error) {
+	rows, err := d.DB.Query(`DELETE FROM upload_sessions WHERE updated_at < $1 RETURNING upload_id`, before)
+	if err != nil {
+		return nil, fmt.Errorf("[Image DB] Error while deleting expired upload sessions: %w", dbError(err))
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
 func (d DataBase) Close() {
 	d.DB.Close()
 }
@@ -0,0 +1,78 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/Niiazgulov/tages.git/internal/storage/storagetest"
+	"github.com/google/uuid"
+)
+
+// The Postgres tests run on the server TAGES_TEST_DATABASE points at, or on one started from
+// initdb and pg_ctl, and are skipped when neither is available.
+
+func TestDataBase(t *testing.T) {
+	storagetest.TestImageDB(t, storagetest.Postgres(t))
+}
+
+func TestImageStoreOnDataBase(t *testing.T) {
+	storagetest.TestImageProcessor(t, storagetest.MemoryStore(storagetest.Postgres(t)))
+}
+
+func TestDiskImageStoreOnDataBase(t *testing.T) {
+	storagetest.TestImageProcessor(t, storagetest.DiskStore(storagetest.Postgres(t)))
+}
+
+// legacySchema is a database as servers left it before image_id was a UUID: old.jpg has an
+// image_id of another form, and a.jpg and b.jpg share one. Each image_id has a version kept.
+const legacySchema = `
+CREATE TABLE images (
+	id SERIAL PRIMARY KEY,
+	filename VARCHAR UNIQUE,
+	created_at VARCHAR,
+	changed_at VARCHAR,
+	image_id VARCHAR,
+	version INT NOT NULL DEFAULT 1);
+CREATE TABLE image_versions (
+	image_id VARCHAR NOT NULL,
+	version INT NOT NULL,
+	size BIGINT NOT NULL,
+	content_type VARCHAR NOT NULL,
+	checksum VARCHAR NOT NULL,
+	created_at VARCHAR NOT NULL,
+	replaced_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (image_id, version));
+INSERT INTO images (filename, created_at, changed_at, image_id, version) VALUES
+	('old.jpg', 'Monday, 02-Jan-06 15:04:05 UTC', 'Monday, 02-Jan-06 15:04:05 UTC', 'legacy-1', 2),
+	('a.jpg', 'Monday, 02-Jan-06 15:04:05 UTC', 'Monday, 02-Jan-06 15:04:05 UTC', 'shared', 2),
+	('b.jpg', 'Monday, 02-Jan-06 15:04:05 UTC', 'Monday, 02-Jan-06 15:04:05 UTC', 'shared', 1);
+INSERT INTO image_versions (image_id, version, size, content_type, checksum, created_at, replaced_at) VALUES
+	('legacy-1', 1, 3, 'image/jpeg', '', 'Monday, 02-Jan-06 15:04:05 UTC', now()),
+	('shared', 1, 4, 'image/jpeg', '', 'Monday, 02-Jan-06 15:04:05 UTC', now());`
+
+func TestMigrateLegacyImageIDs(t *testing.T) {
+	repo := storagetest.PostgresFrom(t, legacySchema)(t)
+
+	ids := map[string]string{}
+	for _, filename := range []string{"old.jpg", "a.jpg", "b.jpg"} {
+		image, err := repo.GetInfo(storage.ImagesInfo{Filename: filename})
+		if err != nil {
+			t.Fatalf("GetInfo(%s): %v", filename, err)
+		}
+		if _, err := uuid.Parse(image.ImageId); err != nil {
+			t.Errorf("%s has image_id %q, want a UUID", filename, image.ImageId)
+		}
+		ids[filename] = image.ImageId
+	}
+	if ids["a.jpg"] == ids["b.jpg"] {
+		t.Errorf("a.jpg and b.jpg still share image_id %s", ids["a.jpg"])
+	}
+
+	// The kept versions follow the first image that had their image_id.
+	for filename, want := range map[string]int{"old.jpg": 1, "a.jpg": 1, "b.jpg": 0} {
+		versions, err := repo.ListVersions(ids[filename])
+		if err != nil || len(versions) != want {
+			t.Errorf("ListVersions(%s) = %d versions, %v, want %d", filename, len(versions), err, want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrQuotaExceeded  = errors.New("storage quota exceeded")
+	ErrOtherNamespace = errors.New("image belongs to another namespace")
+)
+
+// DefaultNamespace is charged for the images of callers that are not known to be in another.
+const DefaultNamespace = "default"
+
+// Quota limits the bytes and the number of images stored. Reaching a soft limit only makes
+// the server warn about it. A zero limit is off.
+type Quota struct {
+	MaxBytes  int64
+	MaxFiles  int64
+	SoftBytes int64
+	SoftFiles int64
+}
+
+// Quotas are the limits of the whole store and of each namespace. Namespace applies to every
+// namespace not listed in Namespaces.
+type Quotas struct {
+	Global     Quota
+	Namespace  Quota
+	Namespaces map[string]Quota
+}
+
+// For returns the quota of namespace.
+func (q Quotas) For(namespace string) Quota {
+	if quota, ok := q.Namespaces[namespace]; ok {
+		return quota
+	}
+
+	return q.Namespace
+}
+
+// Usage is what the images of a namespace, or of the whole store, take. Images in the trash
+// count until they are purged; kept versions are not charged.
+type Usage struct {
+	Bytes int64
+	Files int64
+}
+
+// UsageReport puts the usage of a namespace and of the whole store next to their quotas.
+type UsageReport struct {
+	Namespace  string
+	Usage      Usage
+	Quota      Quota
+	Total      Usage
+	TotalQuota Quota
+}
+
+// SoftLimitsReached describes each soft limit the usage has reached.
+func (r UsageReport) SoftLimitsReached() []string {
+	var reached []string
+	check := func(scope string, used, limit int64, unit string) {
+		if limit > 0 && used >= limit {
+			reached = append(reached, fmt.Sprintf("%s uses %d %s, soft limit %d", scope, used, unit, limit))
+		}
+	}
+	scope := fmt.Sprintf("namespace %q", r.Namespace)
+	check(scope, r.Usage.Bytes, r.Quota.SoftBytes, "bytes")
+	check(scope, r.Usage.Files, r.Quota.SoftFiles, "images")
+	check("storage", r.Total.Bytes, r.TotalQuota.SoftBytes, "bytes")
+	check("storage", r.Total.Files, r.TotalQuota.SoftFiles, "images")
+
+	return reached
+}
+
+// SetQuotas sets the limits enforced when images are saved. It must be called before the
+// store is used; without it nothing is limited.
+func (store *ImageStore) SetQuotas(quotas Quotas) {
+	store.quotas = quotas
+}
+
+// Usage reports the usage of namespace and of the whole store.
+func (store *ImageStore) Usage(namespace string, repo ImageDB) (UsageReport, error) {
+	report := UsageReport{
+		Namespace:  namespace,
+		Quota:      store.quotas.For(namespace),
+		TotalQuota: store.quotas.Global,
+	}
+
+	var err error
+	report.Usage, err = repo.GetUsage(namespace)
+	if err != nil {
+		return UsageReport{}, fmt.Errorf("cannot read namespace usage: %w", err)
+	}
+	report.Total, err = repo.TotalUsage()
+	if err != nil {
+		return UsageReport{}, fmt.Errorf("cannot read storage usage: %w", err)
+	}
+
+	return report, nil
+}
+
+// CheckQuota tells whether saving image, with its Filename, Namespace and Size, would
+// exceed a quota as things stand. Saving checks again, so this only spares receiving an
+// image that is bound to be refused.
+func (store *ImageStore) CheckQuota(image ImagesInfo, repo ImageDB) error {
+	filename, err := CleanFilename(image.Filename)
+	if err != nil {
+		return err
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	existing, err := repo.GetInfo(ImagesInfo{Filename: filename})
+	switch {
+	case err == nil:
+		return store.chargeImage(image, &existing, repo)
+	case errors.Is(err, ErrImgNotFound):
+		return store.chargeImage(image, nil, repo)
+	default:
+		return err
+	}
+}
+
+// chargeImage checks the quotas for saving image in place of existing, if there is one. Only
+// an image of the same namespace can be replaced, which is charged the change in size.
+func (store *ImageStore) chargeImage(image ImagesInfo, existing *ImagesInfo, repo ImageDB) error {
+	if existing != nil {
+		if err := sameNamespace(image.Namespace, *existing); err != nil {
+			return err
+		}
+		return store.charge(existing.Namespace, image.Size-existing.Size, 0, repo)
+	}
+
+	return store.charge(namespaceOrDefault(image.Namespace), image.Size, 1, repo)
+}
+
+// charge returns ErrQuotaExceeded if namespace or the whole store cannot take bytes and files
+// more. Shrinking is always allowed, so an overrun quota can be worked back under.
+func (store *ImageStore) charge(namespace string, bytes, files int64, repo ImageDB) error {
+	if bytes <= 0 && files <= 0 {
+		return nil
+	}
+
+	quota := store.quotas.For(namespace)
+	if quota.MaxBytes > 0 || quota.MaxFiles > 0 {
+		used, err := repo.GetUsage(namespace)
+		if err != nil {
+			return fmt.Errorf("cannot read namespace usage: %w", err)
+		}
+		if over := overrun(used, quota, bytes, files); over != "" {
+			return fmt.Errorf("namespace %q %s: %w", namespace, over, ErrQuotaExceeded)
+		}
+	}
+
+	global := store.quotas.Global
+	if global.MaxBytes > 0 || global.MaxFiles > 0 {
+		used, err := repo.TotalUsage()
+		if err != nil {
+			return fmt.Errorf("cannot read storage usage: %w", err)
+		}
+		if over := overrun(used, global, bytes, files); over != "" {
+			return fmt.Errorf("storage %s: %w", over, ErrQuotaExceeded)
+		}
+	}
+
+	return nil
+}
+
+// overrun describes the hard limit of quota that adding bytes and files to used goes over,
+// or returns an empty string if there is none.
+func overrun(used Usage, quota Quota, bytes, files int64) string {
+	switch {
+	case quota.MaxBytes > 0 && bytes > 0 && used.Bytes+bytes > quota.MaxBytes:
+		return fmt.Sprintf("uses %d bytes, %d more would exceed the limit of %d", used.Bytes, bytes, quota.MaxBytes)
+	case quota.MaxFiles > 0 && files > 0 && used.Files+files > quota.MaxFiles:
+		return fmt.Sprintf("holds %d images, %d more would exceed the limit of %d", used.Files, files, quota.MaxFiles)
+	default:
+		return ""
+	}
+}
+
+// sameNamespace returns ErrOtherNamespace unless record is kept in namespace, the one of the
+// caller about to change it.
+func sameNamespace(namespace string, record ImagesInfo) error {
+	if namespaceOrDefault(namespace) != namespaceOrDefault(record.Namespace) {
+		return fmt.Errorf("%q is kept in namespace %q: %w", record.Filename, record.Namespace, ErrOtherNamespace)
+	}
+
+	return nil
+}
+
+// namespaceOrDefault puts images saved without a namespace into DefaultNamespace.
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return DefaultNamespace
+	}
+	return namespace
+}
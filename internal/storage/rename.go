@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConflictPolicy decides what RenameImage and CopyImage do when the target filename is taken.
+type ConflictPolicy int
+
+const (
+	ConflictFail ConflictPolicy = iota
+	ConflictOverwrite
+	ConflictAutoSuffix
+)
+
+// maxSuffix bounds the search for a free "name (N).ext" under ConflictAutoSuffix.
+const maxSuffix = 1000
+
+// RenameImage gives an image a new filename, keeping its image_id. Only the record changes;
+// an image it replaces goes to the trash. Both have to be kept in the Namespace of image.
+func (store *ImageStore) RenameImage(image ImagesInfo, newFilename string, policy ConflictPolicy, repo ImageDB) (ImagesInfo, error) {
+	newFilename, err := CleanFilename(newFilename)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	src, err := repo.GetInfo(image)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	err = sameNamespace(image.Namespace, src)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	if src.Filename == newFilename {
+		return src, nil
+	}
+
+	target, overwrite, err := store.resolveTarget(newFilename, image.Namespace, policy, repo)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	src.ChangedAt = time.Now()
+
+	return repo.RenameInfo(src, target, overwrite)
+}
+
+// CopyImage stores a copy of an image under newFilename with a fresh image_id. The copy
+// shares the content of the original, so it takes no space until one of them is replaced,
+// but it is charged like any other image to the Namespace of image, which has to keep the
+// original and any image the copy replaces.
+func (store *ImageStore) CopyImage(image ImagesInfo, newFilename string, policy ConflictPolicy, repo ImageDB) (ImagesInfo, error) {
+	newFilename, err := CleanFilename(newFilename)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	src, err := repo.GetInfo(image)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	err = sameNamespace(image.Namespace, src)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	target, overwrite, err := store.resolveTarget(newFilename, image.Namespace, policy, repo)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	namespace := namespaceOrDefault(image.Namespace)
+	err = store.charge(namespace, src.Size, 1, repo)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	imageID, err := uuid.NewRandom()
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("cannot generate image id: %w", err)
+	}
+
+	copied := src
+	copied.ImageId = imageID.String()
+	copied.Filename = target
+	copied.Namespace = namespace
+	copied.CreatedAt = time.Now()
+	copied.ChangedAt = copied.CreatedAt
+	err = repo.CopyInfo(copied, overwrite)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	return copied, nil
+}
+
+// resolveTarget applies policy to newFilename and reports the filename to use and
+// whether an existing image under it is to be replaced, which only one kept in namespace can be.
+func (store *ImageStore) resolveTarget(newFilename, namespace string, policy ConflictPolicy, repo ImageDB) (string, bool, error) {
+	existing, err := repo.GetInfo(ImagesInfo{Filename: newFilename})
+	if errors.Is(err, ErrImgNotFound) {
+		return newFilename, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	switch policy {
+	case ConflictOverwrite:
+		if err := sameNamespace(namespace, existing); err != nil {
+			return "", false, err
+		}
+		return newFilename, true, nil
+	case ConflictAutoSuffix:
+		ext := path.Ext(newFilename)
+		base := strings.TrimSuffix(newFilename, ext)
+		for i := 1; i <= maxSuffix; i++ {
+			candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+			taken, err := store.taken(candidate, repo)
+			if err != nil {
+				return "", false, err
+			}
+			if !taken {
+				return candidate, false, nil
+			}
+		}
+		return "", false, fmt.Errorf("no free name for %s after %d attempts: %w", newFilename, maxSuffix, ErrImgExists)
+	default:
+		return "", false, fmt.Errorf("%s: %w", newFilename, ErrImgExists)
+	}
+}
+
+// taken reports whether filename is used by a record.
+func (store *ImageStore) taken(filename string, repo ImageDB) (bool, error) {
+	_, err := repo.GetInfo(ImagesInfo{Filename: filename})
+	if errors.Is(err, ErrImgNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
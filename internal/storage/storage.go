@@ -1,112 +1,283 @@
 package storage
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"net"
+	"net/http"
+	"path"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Niiazgulov/tages.git/internal/storage/blob"
 	"github.com/google/uuid"
 )
 
 var (
 	ErrImgNotFound = errors.New("image not found")
+	ErrImgExists   = errors.New("image already exists")
+	ErrImgTooLarge = errors.New("image is too large")
+	ErrInvalidName = errors.New("invalid image name")
+	ErrRange       = blob.ErrRange
+	// ErrUnavailable marks failures to reach the backend that may succeed when retried.
+	ErrUnavailable = errors.New("storage backend is unavailable")
 )
 
+// tempPrefix names the blobs of images still being received.
+const tempPrefix = ".upload-"
+
 type ImageProcessor interface {
-	SaveNewImage(img bytes.Buffer, newImage ImagesInfo, repo ImageDB) (string, error)
+	SaveNewImage(img io.Reader, newImage ImagesInfo, repo ImageDB) (string, error)
 	ImagesView(repo ImageDB) ([]ImagesInfo, error)
-	GetImage(filename string) ([]byte, error)
+	SaveKnownImage(newImage ImagesInfo, repo ImageDB) (string, bool, error)
+	GetImage(filename string, repo ImageDB) ([]byte, error)
+	DeleteImage(image ImagesInfo, repo ImageDB) (ImagesInfo, error)
+	RenameImage(image ImagesInfo, newFilename string, policy ConflictPolicy, repo ImageDB) (ImagesInfo, error)
+	CopyImage(image ImagesInfo, newFilename string, policy ConflictPolicy, repo ImageDB) (ImagesInfo, error)
+	OpenImage(filename string, offset, length int64, repo ImageDB) (io.ReadCloser, ImageStat, error)
+	OpenImageVersion(filename string, version int, offset, length int64, repo ImageDB) (io.ReadCloser, ImageStat, error)
+	RestoreVersion(image ImagesInfo, version int, repo ImageDB) (ImagesInfo, error)
+	WritePartial(uploadID string, offset int64, data io.Reader) (int64, error)
+	PartialSize(uploadID string) (int64, error)
+	OpenPartial(uploadID string) (io.ReadCloser, error)
+	RemovePartial(uploadID string) error
+	RemoveStaleTemps(before time.Time) (int, error)
+	CollectGarbage(repo ImageDB, before time.Time) (int, error)
+	ImportLegacyImages(repo ImageDB) (int, int, error)
+	MigrateContentLayout() (int, error)
+	Fsck(repo ImageDB, opts FsckOptions) (FsckReport, error)
+	CheckQuota(image ImagesInfo, repo ImageDB) error
+	Usage(namespace string, repo ImageDB) (UsageReport, error)
 }
 
-type DiskImageStore struct {
-	mutex       sync.RWMutex
-	imageFolder string
+// ImageStore keeps the content of images in a blob store and their records in the DB.
+// Upload sessions are staged in a local folder, since a blob only appears once complete.
+type ImageStore struct {
+	mutex   sync.RWMutex
+	blobs   blob.Store
+	staging string
+	quotas  Quotas
 }
 
 type ImagesInfo struct {
-	ImageId   string
-	Filename  string
-	CreatedAt string
-	ChangedAt string
+	ImageId     string
+	Filename    string
+	CreatedAt   time.Time
+	ChangedAt   time.Time
+	Tags        []string
+	Size        int64
+	ContentType string
+	Checksum    string
+	Version     int
+	DeletedAt   time.Time
+	// Namespace is charged for the image in the quota accounting.
+	Namespace string
+}
+
+type SortOrder int
+
+const (
+	SortByName SortOrder = iota
+	SortByCreated
+	SortByChanged
+)
+
+// ListOptions selects a page of image records. An empty Namespace selects those of every namespace.
+type ListOptions struct {
+	Prefix     string
+	Namespace  string
+	OrderBy    SortOrder
+	Descending bool
+	Offset     int
+	Limit      int
 }
 
-func NewDiskImageStore(imageFolder string) *DiskImageStore {
-	return &DiskImageStore{
-		imageFolder: imageFolder,
+// ImageStat describes the stored content of an image. Offset and Length give the
+// byte range of the reader it was returned with.
+type ImageStat struct {
+	Filename    string
+	Size        int64
+	ContentType string
+	Checksum    string
+	Version     int
+	Offset      int64
+	Length      int64
+}
+
+func NewImageStore(blobs blob.Store, stagingFolder string) *ImageStore {
+	return &ImageStore{
+		blobs:   blobs,
+		staging: stagingFolder,
 	}
 }
 
-func (store *DiskImageStore) SaveNewImage(img bytes.Buffer, newImage ImagesInfo, repo ImageDB) (string, error) {
+// NewDiskImageStore keeps images as files in imageFolder and stages uploads next to them.
+func NewDiskImageStore(imageFolder string) *ImageStore {
+	return NewImageStore(blob.NewDisk(imageFolder), strings.Join([]string{imageFolder, partialFolder}, "/"))
+}
+
+// SaveNewImage streams img into a temporary blob and, once the whole image has been received,
+// stores it as content under its checksum unless that content is already there, so memory
+// use does not depend on the image size and identical images take the space of one. The
+// content a replaced record referred to stays until garbage collection removes it. A positive
+// Size of newImage is the exact length of img and lets the blob store size its writes.
+func (store *ImageStore) SaveNewImage(img io.Reader, newImage ImagesInfo, repo ImageDB) (string, error) {
+	filename, err := CleanFilename(newImage.Filename)
+	if err != nil {
+		return "", err
+	}
+	newImage.Filename = filename
+
 	imageID, err := uuid.NewRandom()
 	if err != nil {
 		return "", fmt.Errorf("cannot generate image id: %w", err)
 	}
 	newImage.ImageId = imageID.String()
 
-	imagePath := strings.Join([]string{store.imageFolder, newImage.Filename}, "/")
+	tmpName := tempPrefix + uuid.NewString()
+	hash := sha256.New()
+	head := &headWriter{}
+	data := io.TeeReader(img, io.MultiWriter(hash, head))
+	if newImage.Size > 0 {
+		data = blob.Sized(data, newImage.Size)
+	}
+	info, err := store.blobs.Put(tmpName, data)
+	if err != nil {
+		return "", blobError("cannot write image", err)
+	}
+	defer store.blobs.Delete(tmpName)
 
-	files := filesInFolderMap(store.imageFolder)
-	_, ok := files[newImage.Filename]
-	if ok || files == nil {
-		err := os.Remove(imagePath)
-		if err != nil {
-			return "", fmt.Errorf("cannot delete old image: %v", err)
-		}
+	newImage.Size = info.Size
+	newImage.Checksum = hex.EncodeToString(hash.Sum(nil))
+	if newImage.ContentType == "" {
+		newImage.ContentType = http.DetectContentType(head.data)
+	}
 
-		file, err := os.Create(imagePath)
-		if err != nil {
-			return "", fmt.Errorf("cannot create image file: %w", err)
-		}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 
-		_, err = img.WriteTo(file)
+	key := contentKey(newImage.Checksum)
+	stored, err := store.exists(key)
+	if err != nil {
+		return "", err
+	}
+	if !stored {
+		err = store.blobs.Copy(tmpName, key)
 		if err != nil {
-			return "", fmt.Errorf("cannot write image to file: %w", err)
+			return "", blobError("cannot move image into storage", err)
 		}
+	}
 
-		store.mutex.Lock()
-		defer store.mutex.Unlock()
-		newImage.ChangedAt = time.Now().Format(time.RFC850)
-		oldImageId, err := repo.UpdateInfo(newImage)
-		if err != nil {
-			return "", fmt.Errorf("cannot save image info to the DB: %v", err)
+	newImage.ImageId, err = store.saveRecord(newImage, repo)
+	if err != nil {
+		if !stored {
+			store.blobs.Delete(key)
 		}
-
-		return oldImageId, nil
+		return "", err
 	}
 
-	file, err := os.Create(imagePath)
+	return newImage.ImageId, nil
+}
+
+// SaveKnownImage saves newImage without receiving its data, referring to content already
+// stored with the Checksum and Size of newImage. It reports false and saves nothing if
+// there is no such content, in which case the image has to be uploaded.
+func (store *ImageStore) SaveKnownImage(newImage ImagesInfo, repo ImageDB) (string, bool, error) {
+	filename, err := CleanFilename(newImage.Filename)
 	if err != nil {
-		return "", fmt.Errorf("cannot create image file: %w", err)
+		return "", false, err
+	}
+	newImage.Filename = filename
+
+	checksum, ok := cleanChecksum(newImage.Checksum)
+	if !ok {
+		return "", false, nil
 	}
+	newImage.Checksum = checksum
 
-	_, err = img.WriteTo(file)
+	imageID, err := uuid.NewRandom()
 	if err != nil {
-		return "", fmt.Errorf("cannot write image to file: %w", err)
+		return "", false, fmt.Errorf("cannot generate image id: %w", err)
 	}
+	newImage.ImageId = imageID.String()
 
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
-	newImage.ChangedAt = newImage.CreatedAt
-	err = repo.SaveNewInfo(newImage)
+
+	info, err := store.blobs.Stat(contentKey(checksum))
+	if errors.Is(err, blob.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, blobError("cannot stat image content", err)
+	}
+	if info.Size != newImage.Size {
+		return "", false, nil
+	}
+
+	if newImage.ContentType == "" {
+		newImage.ContentType, err = store.sniff(contentKey(checksum))
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	newImage.ImageId, err = store.saveRecord(newImage, repo)
+	if err != nil {
+		return "", false, err
+	}
+
+	return newImage.ImageId, true, nil
+}
+
+// saveRecord saves the record of newImage, replacing the one holding its filename if there
+// is one, and returns the image_id the image ends up with. Nothing is saved if that would
+// exceed a quota.
+func (store *ImageStore) saveRecord(newImage ImagesInfo, repo ImageDB) (string, error) {
+	existing, err := repo.GetInfo(ImagesInfo{Filename: newImage.Filename})
+	switch {
+	case err == nil:
+		err = store.chargeImage(newImage, &existing, repo)
+		if err != nil {
+			return "", err
+		}
+		newImage.ChangedAt = time.Now()
+		newImage.ImageId, err = repo.UpdateInfo(newImage)
+	case errors.Is(err, ErrImgNotFound):
+		err = store.chargeImage(newImage, nil, repo)
+		if err != nil {
+			return "", err
+		}
+		newImage.Namespace = namespaceOrDefault(newImage.Namespace)
+		newImage.ChangedAt = newImage.CreatedAt
+		err = repo.SaveNewInfo(newImage)
+	}
 	if err != nil {
-		return "", fmt.Errorf("save image info to the DB: %w", err)
+		return "", fmt.Errorf("cannot save image info to the DB: %w", err)
 	}
 
 	return newImage.ImageId, nil
 }
 
-func (store *DiskImageStore) ImagesView(repo ImageDB) ([]ImagesInfo, error) {
-	files := filesInFolderSlice(store.imageFolder)
-	if files == nil {
-		return nil, fmt.Errorf("storage is empty - no files here: %v", ErrImgNotFound)
+// headWriter keeps the first bytes written to it, enough to sniff the content type.
+type headWriter struct {
+	data []byte
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if room := sniffLength - len(w.data); room > 0 {
+		w.data = append(w.data, p[:min(room, len(p))]...)
 	}
 
-	records, err := repo.GetAllInfo(files)
+	return len(p), nil
+}
+
+func (store *ImageStore) ImagesView(repo ImageDB) ([]ImagesInfo, error) {
+	records, err := allRecords(repo)
 	if err != nil {
 		return nil, fmt.Errorf("cannot download images info from db: %w", err)
 	}
@@ -114,67 +285,136 @@ func (store *DiskImageStore) ImagesView(repo ImageDB) ([]ImagesInfo, error) {
 	return records, nil
 }
 
-func (store *DiskImageStore) GetImage(filename string) ([]byte, error) {
-	files := filesInFolderMap(store.imageFolder)
-	if files == nil {
-		return nil, fmt.Errorf("storage is empty - no files here: %v", ErrImgNotFound)
+// recordPageSize is how many records are read from the DB at a time when all are needed.
+const recordPageSize = 1000
+
+// allRecords reads every image record, sorted by filename.
+func allRecords(repo ImageDB) ([]ImagesInfo, error) {
+	records := []ImagesInfo{}
+	for offset := 0; ; offset += recordPageSize {
+		page, err := repo.ListInfo(ListOptions{OrderBy: SortByName, Offset: offset, Limit: recordPageSize})
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, page...)
+		if len(page) < recordPageSize {
+			return records, nil
+		}
 	}
+}
 
-	_, ok := files[filename]
-	if !ok {
-		return nil, fmt.Errorf("cannot find such image in storage: %v", ErrImgNotFound)
+// DeleteImage moves the image identified by the Filename or ImageId of image to the trash if it
+// is kept in the Namespace of image. Its content stays referenced until the trash is purged and
+// is then left to garbage collection, as other images may share it.
+func (store *ImageStore) DeleteImage(image ImagesInfo, repo ImageDB) (ImagesInfo, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record, err := repo.GetInfo(image)
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("cannot move image to the trash: %w", err)
+	}
+	err = sameNamespace(image.Namespace, record)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	deleted, err := repo.TrashInfo(ImagesInfo{ImageId: record.ImageId})
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("cannot move image to the trash: %w", err)
 	}
 
-	imagePath := strings.Join([]string{store.imageFolder, filename}, "/")
+	return deleted, nil
+}
 
-	file, err := os.Open(imagePath)
+func (store *ImageStore) GetImage(filename string, repo ImageDB) ([]byte, error) {
+	reader, _, err := store.OpenImage(filename, 0, 0, repo)
 	if err != nil {
-		return nil, fmt.Errorf("cannot open file: %v", err)
+		return nil, err
 	}
-	defer file.Close()
+	defer reader.Close()
 
-	byteImg, err := io.ReadAll(file)
+	byteImg, err := io.ReadAll(reader)
 	if err != nil {
-		return nil, fmt.Errorf("cannot io.readall file to byte: %v", err)
+		return nil, blobError("cannot read image", err)
 	}
 
 	return byteImg, nil
 }
 
-func filesInFolderMap(imagesFolderPath string) map[string]int {
-	dir, err := os.Open(imagesFolderPath)
+// OpenImage opens length bytes of a stored image starting at offset; a zero length reads up
+// to the end. The checksum comes from the record and so does the content type, unless the
+// record has none and it is sniffed from the content. The caller must close the reader.
+func (store *ImageStore) OpenImage(filename string, offset, length int64, repo ImageDB) (io.ReadCloser, ImageStat, error) {
+	return store.OpenImageVersion(filename, 0, offset, length, repo)
+}
+
+// sniffLength is how much of an image http.DetectContentType looks at.
+const sniffLength = 512
+
+func (store *ImageStore) sniff(name string) (string, error) {
+	reader, _, err := store.blobs.Get(name, 0, 0)
 	if err != nil {
-		return nil
+		return "", blobError("cannot open image", err)
 	}
-	defer dir.Close()
+	defer reader.Close()
 
-	files, err := dir.Readdir(-1)
+	head, err := io.ReadAll(io.LimitReader(reader, sniffLength))
 	if err != nil {
-		return nil
-	}
-	filenames := make(map[string]int)
-	for i, file := range files {
-		filenames[file.Name()] = i
+		return "", blobError("cannot read image header", err)
 	}
 
-	return filenames
+	return http.DetectContentType(head), nil
 }
 
-func filesInFolderSlice(imagesFolderPath string) []string {
-	dir, err := os.Open(imagesFolderPath)
+// exists reports whether a blob is stored under name.
+func (store *ImageStore) exists(name string) (bool, error) {
+	_, err := store.blobs.Stat(name)
+	if errors.Is(err, blob.ErrNotFound) {
+		return false, nil
+	}
 	if err != nil {
-		return nil
+		return false, blobError("cannot stat image", err)
 	}
-	defer dir.Close()
 
-	files, err := dir.Readdir(-1)
-	if err != nil {
+	return true, nil
+}
+
+// RemoveStaleTemps removes blobs left behind by writes that did not finish, such as after a
+// crash, if they were last modified before the given time.
+func (store *ImageStore) RemoveStaleTemps(before time.Time) (int, error) {
+	var stale []string
+	collect := func(info blob.Info) error {
+		if strings.HasPrefix(path.Base(info.Name), tempPrefix) && info.ModTime.Before(before) {
+			stale = append(stale, info.Name)
+		}
 		return nil
 	}
+	// Disk names its temp files like the store does and leaves them next to the content.
+	for _, prefix := range []string{tempPrefix, contentPrefix} {
+		if err := store.blobs.List(prefix, collect); err != nil {
+			return 0, blobError("cannot list temp blobs", err)
+		}
+	}
+
+	for i, name := range stale {
+		if err := store.blobs.Delete(name); err != nil {
+			return i, blobError("cannot remove temp blob", err)
+		}
+	}
+
+	return len(stale), nil
+}
 
-	filenames := []string{}
-	for _, file := range files {
-		filenames = append(filenames, file.Name())
+// blobError wraps a blob store failure with msg, translating it to the errors of this package.
+func blobError(msg string, err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, blob.ErrNotFound):
+		return fmt.Errorf("%s: %w", msg, ErrImgNotFound)
+	case errors.As(err, &netErr):
+		return fmt.Errorf("%s: %w: %w", msg, ErrUnavailable, err)
+	default:
+		return fmt.Errorf("%s: %w", msg, err)
 	}
-	return filenames
 }
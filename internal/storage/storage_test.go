@@ -0,0 +1,15 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/Niiazgulov/tages.git/internal/storage/storagetest"
+)
+
+func TestImageStore(t *testing.T) {
+	storagetest.TestImageProcessor(t, storagetest.MemoryStore(storagetest.Bolt))
+}
+
+func TestDiskImageStore(t *testing.T) {
+	storagetest.TestImageProcessor(t, storagetest.DiskStore(storagetest.Bolt))
+}
@@ -0,0 +1,565 @@
+package storagetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/google/uuid"
+)
+
+// TestImageDB runs the ImageDB checks, each on a DB of its own from open.
+func TestImageDB(t *testing.T, open OpenDB) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, storage.ImageDB)
+	}{
+		{"SaveAndGet", testSaveAndGet},
+		{"Overwrite", testOverwrite},
+		{"List", testList},
+		{"Missing", testMissing},
+		{"RenameAndCopy", testRenameAndCopy},
+		{"Trash", testTrash},
+		{"TrashNamespaces", testTrashNamespaces},
+		{"OldVersions", testOldVersions},
+		{"UnicodeNames", testUnicodeNames},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"UploadSessions", testUploadSessions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, open(t))
+		})
+	}
+}
+
+// mustSave saves a new record of filename holding data and returns it.
+func mustSave(t *testing.T, repo storage.ImageDB, filename, data string) storage.ImagesInfo {
+	t.Helper()
+
+	record := newRecord(filename, data)
+	if err := repo.SaveNewInfo(record); err != nil {
+		t.Fatalf("SaveNewInfo(%s): %v", filename, err)
+	}
+
+	return record
+}
+
+// mustGet returns the live record of filename.
+func mustGet(t *testing.T, repo storage.ImageDB, filename string) storage.ImagesInfo {
+	t.Helper()
+
+	record, err := repo.GetInfo(storage.ImagesInfo{Filename: filename})
+	if err != nil {
+		t.Fatalf("GetInfo(%s): %v", filename, err)
+	}
+
+	return record
+}
+
+// wantUsage fails t unless namespace uses bytes in files images.
+func wantUsage(t *testing.T, repo storage.ImageDB, namespace string, bytes, files int64) {
+	t.Helper()
+
+	usage, err := repo.GetUsage(namespace)
+	if err != nil {
+		t.Fatalf("GetUsage(%s): %v", namespace, err)
+	}
+	if usage != (storage.Usage{Bytes: bytes, Files: files}) {
+		t.Errorf("usage of %s is %+v, want %d bytes in %d images", namespace, usage, bytes, files)
+	}
+}
+
+// wantRefs fails t unless the content with each checksum of want has that many references.
+// A negative count means the content must be unknown.
+func wantRefs(t *testing.T, repo storage.ImageDB, want map[string]int) {
+	t.Helper()
+
+	checksums := make([]string, 0, len(want))
+	for sum := range want {
+		checksums = append(checksums, sum)
+	}
+	refs, err := repo.ContentRefs(checksums)
+	if err != nil {
+		t.Fatalf("ContentRefs: %v", err)
+	}
+	for sum, n := range want {
+		got, known := refs[sum]
+		switch {
+		case n < 0 && known:
+			t.Errorf("content %s is still known with %d references", sum, got)
+		case n >= 0 && got != n:
+			t.Errorf("content %s has %d references, want %d", sum, got, n)
+		}
+	}
+}
+
+func testSaveAndGet(t *testing.T, repo storage.ImageDB) {
+	saved := mustSave(t, repo, "a.jpg", "aaa")
+
+	for _, key := range []storage.ImagesInfo{{Filename: "a.jpg"}, {ImageId: saved.ImageId}} {
+		got, err := repo.GetInfo(key)
+		if err != nil {
+			t.Fatalf("GetInfo(%+v): %v", key, err)
+		}
+		if got.ImageId != saved.ImageId || got.Filename != saved.Filename || got.Size != saved.Size ||
+			got.Checksum != saved.Checksum || got.ContentType != saved.ContentType {
+			t.Errorf("GetInfo(%+v) = %+v, want %+v", key, got, saved)
+		}
+		if got.Version != 1 || got.Namespace != storage.DefaultNamespace || !got.DeletedAt.IsZero() {
+			t.Errorf("new record has version %d, namespace %q, deleted at %v", got.Version, got.Namespace, got.DeletedAt)
+		}
+		if !equal(got.Tags, saved.Tags) {
+			t.Errorf("tags are %v, want %v", got.Tags, saved.Tags)
+		}
+		if got.CreatedAt.Sub(saved.CreatedAt).Abs() > time.Millisecond {
+			t.Errorf("created at %v, want %v", got.CreatedAt, saved.CreatedAt)
+		}
+	}
+
+	all, err := repo.GetAllInfo([]string{"a.jpg", "missing.jpg"})
+	if err != nil {
+		t.Fatalf("GetAllInfo: %v", err)
+	}
+	if names := filenames(all); !equal(names, []string{"a.jpg"}) {
+		t.Errorf("GetAllInfo returned %v, want [a.jpg]", names)
+	}
+
+	wantRefs(t, repo, map[string]int{saved.Checksum: 1})
+	wantUsage(t, repo, storage.DefaultNamespace, 3, 1)
+}
+
+func testOverwrite(t *testing.T, repo storage.ImageDB) {
+	first := mustSave(t, repo, "a.jpg", "first")
+	second := newRecord("a.jpg", "second!")
+	second.ChangedAt = first.CreatedAt.Add(time.Second)
+
+	// Saving over a live record updates it, keeping the first image_id.
+	if err := repo.SaveNewInfo(second); err != nil {
+		t.Fatalf("SaveNewInfo over a.jpg: %v", err)
+	}
+	got := mustGet(t, repo, "a.jpg")
+	if got.ImageId != first.ImageId || got.Checksum != second.Checksum || got.Size != second.Size || got.Version != 2 {
+		t.Errorf("overwritten record is %+v, want image_id %s, checksum %s, version 2", got, first.ImageId, second.Checksum)
+	}
+
+	versions, err := repo.ListVersions(first.ImageId)
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Version != 1 || versions[0].Checksum != first.Checksum {
+		t.Fatalf("versions are %+v, want version 1 with %s", versions, first.Checksum)
+	}
+	kept, err := repo.GetVersion(first.ImageId, 1)
+	if err != nil || kept.Size != first.Size {
+		t.Errorf("GetVersion(1) = %+v, %v, want size %d", kept, err, first.Size)
+	}
+	wantRefs(t, repo, map[string]int{first.Checksum: 1, second.Checksum: 1})
+	wantUsage(t, repo, storage.DefaultNamespace, second.Size, 1)
+
+	// Saving the same content again changes no version.
+	second.Tags = []string{"retagged"}
+	imageID, err := repo.UpdateInfo(second)
+	if err != nil {
+		t.Fatalf("UpdateInfo: %v", err)
+	}
+	got = mustGet(t, repo, "a.jpg")
+	if imageID != first.ImageId || got.Version != 2 || !equal(got.Tags, second.Tags) {
+		t.Errorf("record after retagging is %+v, image_id %s", got, imageID)
+	}
+	wantRefs(t, repo, map[string]int{second.Checksum: 1})
+}
+
+func testList(t *testing.T, repo storage.ImageDB) {
+	start := time.Now().Add(-time.Hour)
+	// Punctuation sorts the same whether or not a collation ignores it.
+	for i, name := range []string{"b.jpg", "x%1.jpg", "a.jpg", "xa1.jpg", "y_1.jpg", "yb1.jpg"} {
+		record := newRecord(name, name)
+		record.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		record.ChangedAt = start.Add(time.Duration(10-i) * time.Minute)
+		if err := repo.SaveNewInfo(record); err != nil {
+			t.Fatalf("SaveNewInfo(%s): %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		opts storage.ListOptions
+		want []string
+	}{
+		{storage.ListOptions{Limit: 10}, []string{"a.jpg", "b.jpg", "x%1.jpg", "xa1.jpg", "y_1.jpg", "yb1.jpg"}},
+		{storage.ListOptions{Descending: true, Offset: 1, Limit: 2}, []string{"y_1.jpg", "xa1.jpg"}},
+		{storage.ListOptions{OrderBy: storage.SortByCreated, Limit: 3}, []string{"b.jpg", "x%1.jpg", "a.jpg"}},
+		{storage.ListOptions{OrderBy: storage.SortByChanged, Limit: 2}, []string{"yb1.jpg", "y_1.jpg"}},
+		{storage.ListOptions{OrderBy: storage.SortByCreated, Descending: true, Limit: 1}, []string{"yb1.jpg"}},
+		// Wildcards in a prefix match only themselves.
+		{storage.ListOptions{Prefix: "x%", Limit: 10}, []string{"x%1.jpg"}},
+		{storage.ListOptions{Prefix: "y_", Limit: 10}, []string{"y_1.jpg"}},
+		{storage.ListOptions{Prefix: "z", Limit: 10}, []string{}},
+		{storage.ListOptions{Prefix: "x", Descending: true, Limit: 10}, []string{"xa1.jpg", "x%1.jpg"}},
+		{storage.ListOptions{Prefix: "y", Descending: true, Offset: 1, Limit: 10}, []string{"y_1.jpg"}},
+		{storage.ListOptions{Prefix: "a", Descending: true, Limit: 10}, []string{"a.jpg"}},
+		{storage.ListOptions{Prefix: "z", Descending: true, Limit: 10}, []string{}},
+		{storage.ListOptions{Prefix: "0", Descending: true, Limit: 10}, []string{}},
+		{storage.ListOptions{Offset: 6, Limit: 10}, []string{}},
+	}
+	for _, tt := range tests {
+		records, err := repo.ListInfo(tt.opts)
+		if err != nil {
+			t.Fatalf("ListInfo(%+v): %v", tt.opts, err)
+		}
+		if names := filenames(records); !equal(names, tt.want) {
+			t.Errorf("ListInfo(%+v) = %v, want %v", tt.opts, names, tt.want)
+		}
+	}
+}
+
+func testMissing(t *testing.T, repo storage.ImageDB) {
+	mustSave(t, repo, "present.jpg", "here")
+	missing := storage.ImagesInfo{Filename: "missing.jpg", ChangedAt: time.Now()}
+
+	_, err := repo.GetInfo(missing)
+	wantErr(t, "GetInfo", err, storage.ErrImgNotFound)
+	_, err = repo.GetInfo(storage.ImagesInfo{ImageId: uuid.NewString()})
+	wantErr(t, "GetInfo by image_id", err, storage.ErrImgNotFound)
+	_, err = repo.DeleteInfo(missing)
+	wantErr(t, "DeleteInfo", err, storage.ErrImgNotFound)
+	_, err = repo.TrashInfo(missing)
+	wantErr(t, "TrashInfo", err, storage.ErrImgNotFound)
+	_, err = repo.RestoreInfo(missing)
+	wantErr(t, "RestoreInfo", err, storage.ErrImgNotFound)
+	_, err = repo.RenameInfo(missing, "other.jpg", false)
+	wantErr(t, "RenameInfo", err, storage.ErrImgNotFound)
+	_, err = repo.GetVersion(uuid.NewString(), 1)
+	wantErr(t, "GetVersion", err, storage.ErrVersionNotFound)
+
+	versions, err := repo.ListVersions(uuid.NewString())
+	if err != nil || len(versions) != 0 {
+		t.Errorf("ListVersions of a missing image = %v, %v, want none", versions, err)
+	}
+	wantUsage(t, repo, "nobody", 0, 0)
+	mustGet(t, repo, "present.jpg")
+}
+
+func testRenameAndCopy(t *testing.T, repo storage.ImageDB) {
+	a := mustSave(t, repo, "a.jpg", "aaa")
+	b := mustSave(t, repo, "b.jpg", "bb")
+
+	_, err := repo.RenameInfo(storage.ImagesInfo{Filename: "a.jpg", ChangedAt: time.Now()}, "b.jpg", false)
+	wantErr(t, "RenameInfo onto a taken name", err, storage.ErrImgExists)
+
+	renamed, err := repo.RenameInfo(storage.ImagesInfo{ImageId: a.ImageId, ChangedAt: time.Now()}, "c.jpg", false)
+	if err != nil {
+		t.Fatalf("RenameInfo: %v", err)
+	}
+	if renamed.ImageId != a.ImageId || renamed.Filename != "c.jpg" {
+		t.Errorf("renamed record is %+v", renamed)
+	}
+	_, err = repo.GetInfo(storage.ImagesInfo{Filename: "a.jpg"})
+	wantErr(t, "GetInfo of the old name", err, storage.ErrImgNotFound)
+
+	// Overwriting moves the record holding the name to the trash.
+	_, err = repo.RenameInfo(storage.ImagesInfo{Filename: "c.jpg", ChangedAt: time.Now()}, "b.jpg", true)
+	if err != nil {
+		t.Fatalf("RenameInfo with overwrite: %v", err)
+	}
+	if got := mustGet(t, repo, "b.jpg"); got.ImageId != a.ImageId {
+		t.Errorf("b.jpg is image %s, want %s", got.ImageId, a.ImageId)
+	}
+	trash, err := repo.ListTrash(storage.ListOptions{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListTrash: %v", err)
+	}
+	if len(trash) != 1 || trash[0].ImageId != b.ImageId {
+		t.Errorf("trash holds %+v, want the replaced b.jpg", trash)
+	}
+
+	copied := newRecord("copy.jpg", "aaa")
+	if err := repo.CopyInfo(copied, false); err != nil {
+		t.Fatalf("CopyInfo: %v", err)
+	}
+	err = repo.CopyInfo(newRecord("copy.jpg", "aaa"), false)
+	wantErr(t, "CopyInfo onto a taken name", err, storage.ErrImgExists)
+
+	wantRefs(t, repo, map[string]int{a.Checksum: 2, b.Checksum: 1})
+	wantUsage(t, repo, storage.DefaultNamespace, a.Size+b.Size+copied.Size, 3)
+}
+
+func testTrash(t *testing.T, repo storage.ImageDB) {
+	first := mustSave(t, repo, "a.jpg", "first")
+	trashed, err := repo.TrashInfo(storage.ImagesInfo{Filename: "a.jpg"})
+	if err != nil {
+		t.Fatalf("TrashInfo: %v", err)
+	}
+	if trashed.ImageId != first.ImageId || trashed.DeletedAt.IsZero() {
+		t.Errorf("trashed record is %+v", trashed)
+	}
+	_, err = repo.GetInfo(storage.ImagesInfo{Filename: "a.jpg"})
+	wantErr(t, "GetInfo of a trashed image", err, storage.ErrImgNotFound)
+	listed, err := repo.ListInfo(storage.ListOptions{Limit: 10})
+	if err != nil || len(listed) != 0 {
+		t.Errorf("ListInfo = %v, %v, want no live images", filenames(listed), err)
+	}
+
+	// The name is free again while the trashed image waits.
+	second := mustSave(t, repo, "a.jpg", "second")
+	_, err = repo.RestoreInfo(storage.ImagesInfo{Filename: "a.jpg"})
+	wantErr(t, "RestoreInfo onto a taken name", err, storage.ErrImgExists)
+
+	if _, err := repo.DeleteInfo(storage.ImagesInfo{ImageId: second.ImageId}); err != nil {
+		t.Fatalf("DeleteInfo: %v", err)
+	}
+	restored, err := repo.RestoreInfo(storage.ImagesInfo{Filename: "a.jpg"})
+	if err != nil {
+		t.Fatalf("RestoreInfo: %v", err)
+	}
+	if restored.ImageId != first.ImageId || !restored.DeletedAt.IsZero() {
+		t.Errorf("restored record is %+v", restored)
+	}
+	wantRefs(t, repo, map[string]int{first.Checksum: 1, second.Checksum: 0})
+
+	if _, err := repo.TrashInfo(storage.ImagesInfo{ImageId: first.ImageId}); err != nil {
+		t.Fatalf("TrashInfo by image_id: %v", err)
+	}
+	// Trashed images count until they are purged.
+	wantUsage(t, repo, storage.DefaultNamespace, first.Size, 1)
+
+	n, err := repo.PurgeTrash("", time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Errorf("PurgeTrash of older images = %d, %v, want 0", n, err)
+	}
+	n, err = repo.PurgeTrash("", time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Errorf("PurgeTrash = %d, %v, want 1", n, err)
+	}
+	trash, err := repo.ListTrash(storage.ListOptions{Limit: 10})
+	if err != nil || len(trash) != 0 {
+		t.Errorf("ListTrash after purge = %v, %v", filenames(trash), err)
+	}
+	wantRefs(t, repo, map[string]int{first.Checksum: 0})
+	wantUsage(t, repo, storage.DefaultNamespace, 0, 0)
+}
+
+// mustTrash saves a record of filename holding data in namespace and moves it to the trash.
+func mustTrash(t *testing.T, repo storage.ImageDB, namespace, filename, data string) storage.ImagesInfo {
+	t.Helper()
+
+	record := newRecord(filename, data)
+	record.Namespace = namespace
+	if err := repo.SaveNewInfo(record); err != nil {
+		t.Fatalf("SaveNewInfo(%s): %v", filename, err)
+	}
+	trashed, err := repo.TrashInfo(storage.ImagesInfo{ImageId: record.ImageId})
+	if err != nil {
+		t.Fatalf("TrashInfo(%s): %v", filename, err)
+	}
+
+	return trashed
+}
+
+func testTrashNamespaces(t *testing.T, repo storage.ImageDB) {
+	a := mustTrash(t, repo, "alice", "a.jpg", "alice's")
+	mustTrash(t, repo, "bob", "b.jpg", "bob's")
+	own := mustTrash(t, repo, "alice", "x.jpg", "alice's x")
+	mustTrash(t, repo, "bob", "x.jpg", "bob's x")
+
+	trash, err := repo.ListTrash(storage.ListOptions{Namespace: "alice", Limit: 10})
+	if names := filenames(trash); err != nil || !equal(names, []string{"x.jpg", "a.jpg"}) {
+		t.Errorf("ListTrash of alice = %v, %v, want [x.jpg a.jpg]", names, err)
+	}
+	trash, err = repo.ListTrash(storage.ListOptions{Limit: 10})
+	if err != nil || len(trash) != 4 {
+		t.Errorf("ListTrash of every namespace = %v, %v, want 4 images", filenames(trash), err)
+	}
+
+	_, err = repo.RestoreInfo(storage.ImagesInfo{Filename: "a.jpg", Namespace: "bob"})
+	wantErr(t, "RestoreInfo of another namespace's image by name", err, storage.ErrOtherNamespace)
+	_, err = repo.RestoreInfo(storage.ImagesInfo{ImageId: a.ImageId, Namespace: "bob"})
+	wantErr(t, "RestoreInfo of another namespace's image by image_id", err, storage.ErrOtherNamespace)
+
+	// A name restores the caller's own image even if another namespace deleted one later.
+	restored, err := repo.RestoreInfo(storage.ImagesInfo{Filename: "x.jpg", Namespace: "alice"})
+	if err != nil || restored.ImageId != own.ImageId {
+		t.Errorf("RestoreInfo of alice's x.jpg = %+v, %v, want image %s", restored, err, own.ImageId)
+	}
+
+	n, err := repo.PurgeTrash("alice", time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Errorf("PurgeTrash of alice = %d, %v, want 1", n, err)
+	}
+	trash, err = repo.ListTrash(storage.ListOptions{Limit: 10})
+	if names := filenames(trash); err != nil || !equal(names, []string{"x.jpg", "b.jpg"}) {
+		t.Errorf("ListTrash after purging alice's = %v, %v, want bob's [x.jpg b.jpg]", names, err)
+	}
+	wantUsage(t, repo, "alice", own.Size, 1)
+}
+
+func testOldVersions(t *testing.T, repo storage.ImageDB) {
+	var saved []storage.ImagesInfo
+	for i := 0; i < 4; i++ {
+		record := newRecord("v.jpg", fmt.Sprintf("content %d", i))
+		if err := repo.SaveNewInfo(record); err != nil {
+			t.Fatalf("SaveNewInfo #%d: %v", i, err)
+		}
+		saved = append(saved, record)
+	}
+	imageID := saved[0].ImageId
+
+	versions, err := repo.ListVersions(imageID)
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("%d versions kept, want 3", len(versions))
+	}
+	for i, version := range versions {
+		if want := 3 - i; version.Version != want || version.Checksum != saved[want-1].Checksum {
+			t.Errorf("version #%d is %d with %s, want %d with %s", i, version.Version, version.Checksum,
+				want, saved[want-1].Checksum)
+		}
+	}
+
+	n, err := repo.DeleteOldVersions(1, time.Time{})
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteOldVersions(1) = %d, %v, want 2", n, err)
+	}
+	versions, err = repo.ListVersions(imageID)
+	if err != nil || len(versions) != 1 || versions[0].Version != 3 {
+		t.Errorf("versions left are %+v, %v, want version 3", versions, err)
+	}
+	wantRefs(t, repo, map[string]int{saved[0].Checksum: 0, saved[1].Checksum: 0, saved[2].Checksum: 1})
+
+	if err := repo.DeleteUnreferencedContent([]string{saved[0].Checksum, saved[2].Checksum}); err != nil {
+		t.Fatalf("DeleteUnreferencedContent: %v", err)
+	}
+	wantRefs(t, repo, map[string]int{saved[0].Checksum: -1, saved[1].Checksum: 0, saved[2].Checksum: 1})
+
+	n, err = repo.DeleteOldVersions(0, time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Errorf("DeleteOldVersions by age = %d, %v, want 1", n, err)
+	}
+}
+
+func testUnicodeNames(t *testing.T, repo storage.ImageDB) {
+	names := []string{"фото.jpg", "写真 猫.png", "emoji 😀.gif", "café.jpg"}
+	for _, name := range names {
+		saved := mustSave(t, repo, name, name)
+		if got := mustGet(t, repo, name); got.ImageId != saved.ImageId || got.Filename != name {
+			t.Errorf("GetInfo(%s) = %+v", name, got)
+		}
+	}
+
+	records, err := repo.ListInfo(storage.ListOptions{Prefix: "写真", Limit: 10})
+	if err != nil {
+		t.Fatalf("ListInfo: %v", err)
+	}
+	if got := filenames(records); !equal(got, []string{"写真 猫.png"}) {
+		t.Errorf("ListInfo with a unicode prefix = %v", got)
+	}
+
+	renamed, err := repo.RenameInfo(storage.ImagesInfo{Filename: "фото.jpg", ChangedAt: time.Now()}, "снимок.jpg", false)
+	if err != nil || renamed.Filename != "снимок.jpg" {
+		t.Errorf("RenameInfo = %+v, %v", renamed, err)
+	}
+}
+
+func testConcurrentWriters(t *testing.T, repo storage.ImageDB) {
+	const writers = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.SaveNewInfo(newRecord(fmt.Sprintf("own %d.jpg", i), fmt.Sprintf("own %d", i)))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.SaveNewInfo(newRecord("shared.jpg", fmt.Sprintf("shared %d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent SaveNewInfo: %v", err)
+		}
+	}
+
+	records, err := repo.ListInfo(storage.ListOptions{Limit: 100})
+	if err != nil {
+		t.Fatalf("ListInfo: %v", err)
+	}
+	if len(records) != writers+1 {
+		t.Errorf("%d live images, want %d: %v", len(records), writers+1, filenames(records))
+	}
+
+	// Every write to the shared image but the first replaced content that is kept as a version.
+	shared := mustGet(t, repo, "shared.jpg")
+	versions, err := repo.ListVersions(shared.ImageId)
+	if err != nil {
+		t.Fatalf("ListVersions: %v", err)
+	}
+	if shared.Version != writers || len(versions) != writers-1 {
+		t.Errorf("shared.jpg is at version %d with %d kept, want %d with %d", shared.Version, len(versions),
+			writers, writers-1)
+	}
+
+	total, err := repo.TotalUsage()
+	if err != nil {
+		t.Fatalf("TotalUsage: %v", err)
+	}
+	var bytes int64
+	for _, record := range records {
+		bytes += record.Size
+	}
+	if total != (storage.Usage{Bytes: bytes, Files: writers + 1}) {
+		t.Errorf("total usage is %+v, want %d bytes in %d images", total, bytes, writers+1)
+	}
+}
+
+func testUploadSessions(t *testing.T, repo storage.ImageDB) {
+	now := time.Now()
+	session := storage.UploadSession{
+		UploadID:  uuid.NewString(),
+		Filename:  "big.jpg",
+		Size:      1000,
+		Checksum:  checksum("big"),
+		CreatedAt: now.Add(-time.Hour),
+	}
+	if err := repo.SaveUploadSession(session); err != nil {
+		t.Fatalf("SaveUploadSession: %v", err)
+	}
+	got, err := repo.GetUploadSession(session.UploadID)
+	if err != nil {
+		t.Fatalf("GetUploadSession: %v", err)
+	}
+	if got.Filename != session.Filename || got.Size != session.Size || got.Received != 0 ||
+		got.Namespace != storage.DefaultNamespace {
+		t.Errorf("GetUploadSession = %+v, want %+v", got, session)
+	}
+
+	if err := repo.UpdateUploadSession(session.UploadID, 500); err != nil {
+		t.Fatalf("UpdateUploadSession: %v", err)
+	}
+	got, err = repo.GetUploadSession(session.UploadID)
+	if err != nil || got.Received != 500 || !got.UpdatedAt.After(got.CreatedAt) {
+		t.Errorf("updated session is %+v, %v", got, err)
+	}
+	wantErr(t, "UpdateUploadSession of a missing session", repo.UpdateUploadSession(uuid.NewString(), 1),
+		storage.ErrUploadNotFound)
+
+	expired, err := repo.DeleteExpiredUploadSessions(now.Add(-time.Minute))
+	if err != nil || len(expired) != 0 {
+		t.Errorf("DeleteExpiredUploadSessions of a fresh session = %v, %v", expired, err)
+	}
+	expired, err = repo.DeleteExpiredUploadSessions(time.Now().Add(time.Second))
+	if err != nil || len(expired) != 1 || expired[0] != session.UploadID {
+		t.Errorf("DeleteExpiredUploadSessions = %v, %v, want [%s]", expired, err, session.UploadID)
+	}
+	_, err = repo.GetUploadSession(session.UploadID)
+	wantErr(t, "GetUploadSession of an expired session", err, storage.ErrUploadNotFound)
+}
@@ -0,0 +1,143 @@
+package storagetest
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// PostgresEnv names the variable holding the connection string of a Postgres server the
+// tests may create databases on.
+const PostgresEnv = "TAGES_TEST_DATABASE"
+
+// Postgres returns an OpenDB giving each test a DataBase on a database of its own, dropped
+// when the test ends. The databases are created on the server PostgresEnv points at or, if it
+// is not set, on a server started from the initdb and pg_ctl in PATH for the time t runs. t
+// is skipped when there is neither.
+func Postgres(t *testing.T) OpenDB {
+	t.Helper()
+
+	return PostgresFrom(t, "")
+}
+
+// PostgresFrom is Postgres run on databases that schema, if it is not empty, was executed on
+// before the DataBase opened them, as on a database left by an older server to be migrated.
+func PostgresFrom(t *testing.T, schema string) OpenDB {
+	t.Helper()
+
+	dsn := os.Getenv(PostgresEnv)
+	if dsn == "" {
+		dsn = startPostgres(t)
+	}
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("cannot connect to Postgres: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	if err := admin.Ping(); err != nil {
+		t.Skipf("Postgres is not available: %v", err)
+	}
+
+	return func(t *testing.T) storage.ImageDB {
+		t.Helper()
+
+		name := "tages_test_" + uuid.NewString()[:8]
+		if _, err := admin.Exec(fmt.Sprintf(`CREATE DATABASE %q`, name)); err != nil {
+			t.Fatalf("cannot create test database: %v", err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec(fmt.Sprintf(`DROP DATABASE IF EXISTS %q`, name)); err != nil {
+				t.Errorf("cannot drop test database %s: %v", name, err)
+			}
+		})
+
+		config, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			t.Fatalf("cannot parse %s: %v", PostgresEnv, err)
+		}
+		config.Database = name
+		dbPath := stdlib.RegisterConnConfig(config)
+		if schema != "" {
+			db, err := sql.Open("pgx", dbPath)
+			if err != nil {
+				t.Fatalf("cannot open test database: %v", err)
+			}
+			_, err = db.Exec(schema)
+			db.Close()
+			if err != nil {
+				t.Fatalf("cannot prepare test database: %v", err)
+			}
+		}
+		repo, err := storage.NewDB(dbPath)
+		if err != nil {
+			t.Fatalf("cannot open test database: %v", err)
+		}
+		// Registered after the drop, so it runs first.
+		t.Cleanup(repo.Close)
+
+		return repo
+	}
+}
+
+// startPostgres starts a throwaway server listening on a socket in a temporary folder and
+// returns its connection string. t is skipped if no server can be started.
+func startPostgres(t *testing.T) string {
+	t.Helper()
+
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		t.Skipf("%s is not set and initdb is not in PATH", PostgresEnv)
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		t.Skipf("%s is not set and pg_ctl is not in PATH", PostgresEnv)
+	}
+
+	dir, err := os.MkdirTemp("", "tages-pg")
+	if err != nil {
+		t.Fatalf("cannot create Postgres folder: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	data := filepath.Join(dir, "data")
+
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").
+		CombinedOutput(); err != nil {
+		t.Skipf("cannot initialize a Postgres cluster: %v\n%s", err, bytes.TrimSpace(out))
+	}
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("cannot pick a Postgres port: %v", err)
+	}
+	options := fmt.Sprintf("-c listen_addresses='' -k %s -p %d -F", dir, port)
+	if out, err := exec.Command(pgCtl, "-D", data, "-o", options, "-l", filepath.Join(dir, "log"), "-w", "start").
+		CombinedOutput(); err != nil {
+		t.Skipf("cannot start Postgres: %v\n%s", err, bytes.TrimSpace(out))
+	}
+	t.Cleanup(func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+	})
+
+	return fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port)
+}
+
+// freePort returns a TCP port nothing listens on, which names the socket of the server.
+func freePort() (int, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer lis.Close()
+
+	return lis.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,305 @@
+package storagetest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+)
+
+// TestImageProcessor runs the ImageProcessor checks, each on a store of its own from open.
+func TestImageProcessor(t *testing.T, open OpenStore) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, storage.ImageProcessor, storage.ImageDB)
+	}{
+		{"SaveAndRead", testSaveAndRead},
+		{"OverwriteImage", testOverwriteImage},
+		{"ListImages", testListImages},
+		{"MissingImage", testMissingImage},
+		{"SharedContent", testSharedContent},
+		{"Namespaces", testNamespaces},
+		{"UnicodeImageNames", testUnicodeImageNames},
+		{"ConcurrentImageWriters", testConcurrentImageWriters},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, repo := open(t)
+			tt.run(t, store, repo)
+		})
+	}
+}
+
+// mustUpload saves data as the image filename and returns its image_id.
+func mustUpload(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB, filename, data string) string {
+	t.Helper()
+
+	imageID, err := store.SaveNewImage(strings.NewReader(data), storage.ImagesInfo{Filename: filename, CreatedAt: time.Now()}, repo)
+	if err != nil {
+		t.Fatalf("SaveNewImage(%s): %v", filename, err)
+	}
+
+	return imageID
+}
+
+// wantImage fails t unless the image filename holds data.
+func wantImage(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB, filename, data string) {
+	t.Helper()
+
+	got, err := store.GetImage(filename, repo)
+	if err != nil {
+		t.Fatalf("GetImage(%s): %v", filename, err)
+	}
+	if string(got) != data {
+		t.Errorf("%s holds %q, want %q", filename, got, data)
+	}
+}
+
+func testSaveAndRead(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB) {
+	data := "\x89PNG\r\n\x1a\n" + strings.Repeat("pixels", 1000)
+	imageID := mustUpload(t, store, repo, "a.png", data)
+	wantImage(t, store, repo, "a.png", data)
+
+	reader, stat, err := store.OpenImage("a.png", 8, 6, repo)
+	if err != nil {
+		t.Fatalf("OpenImage: %v", err)
+	}
+	part, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(part) != "pixels" {
+		t.Errorf("bytes 8-13 are %q, %v, want pixels", part, err)
+	}
+	if stat.Size != int64(len(data)) || stat.Checksum != checksum(data) || stat.ContentType != "image/png" || stat.Version != 1 {
+		t.Errorf("OpenImage stat is %+v", stat)
+	}
+
+	record, err := repo.GetInfo(storage.ImagesInfo{ImageId: imageID})
+	if err != nil {
+		t.Fatalf("GetInfo: %v", err)
+	}
+	if record.Filename != "a.png" || record.Size != int64(len(data)) || record.Checksum != checksum(data) {
+		t.Errorf("saved record is %+v", record)
+	}
+
+	_, _, err = store.OpenImage("a.png", int64(len(data))+1, 0, repo)
+	wantErr(t, "OpenImage past the end", err, storage.ErrRange)
+}
+
+func testOverwriteImage(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB) {
+	imageID := mustUpload(t, store, repo, "a.txt", "first")
+	if again := mustUpload(t, store, repo, "a.txt", "second"); again != imageID {
+		t.Errorf("overwriting changed the image_id from %s to %s", imageID, again)
+	}
+	wantImage(t, store, repo, "a.txt", "second")
+
+	reader, stat, err := store.OpenImageVersion("a.txt", 1, 0, 0, repo)
+	if err != nil {
+		t.Fatalf("OpenImageVersion(1): %v", err)
+	}
+	old, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(old) != "first" || stat.Version != 1 {
+		t.Errorf("version 1 holds %q at version %d, want first", old, stat.Version)
+	}
+
+	restored, err := store.RestoreVersion(storage.ImagesInfo{Filename: "a.txt"}, 1, repo)
+	if err != nil {
+		t.Fatalf("RestoreVersion: %v", err)
+	}
+	if restored.Version != 3 {
+		t.Errorf("restored image is at version %d, want 3", restored.Version)
+	}
+	wantImage(t, store, repo, "a.txt", "first")
+}
+
+func testListImages(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB) {
+	for _, name := range []string{"c.jpg", "a.jpg", "b.jpg"} {
+		mustUpload(t, store, repo, name, name)
+	}
+	if _, err := store.DeleteImage(storage.ImagesInfo{Filename: "b.jpg"}, repo); err != nil {
+		t.Fatalf("DeleteImage: %v", err)
+	}
+
+	records, err := store.ImagesView(repo)
+	if err != nil {
+		t.Fatalf("ImagesView: %v", err)
+	}
+	if names := filenames(records); !equal(names, []string{"a.jpg", "c.jpg"}) {
+		t.Errorf("ImagesView = %v, want [a.jpg c.jpg]", names)
+	}
+	for _, record := range records {
+		if record.CreatedAt.IsZero() || record.ChangedAt.Before(record.CreatedAt) {
+			t.Errorf("%s was created at %v and changed at %v", record.Filename, record.CreatedAt, record.ChangedAt)
+		}
+	}
+}
+
+func testMissingImage(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB) {
+	_, err := store.GetImage("missing.jpg", repo)
+	wantErr(t, "GetImage", err, storage.ErrImgNotFound)
+	_, _, err = store.OpenImageVersion("missing.jpg", 1, 0, 0, repo)
+	wantErr(t, "OpenImageVersion", err, storage.ErrImgNotFound)
+	_, err = store.DeleteImage(storage.ImagesInfo{Filename: "missing.jpg"}, repo)
+	wantErr(t, "DeleteImage", err, storage.ErrImgNotFound)
+	_, err = store.RenameImage(storage.ImagesInfo{Filename: "missing.jpg"}, "other.jpg", storage.ConflictFail, repo)
+	wantErr(t, "RenameImage", err, storage.ErrImgNotFound)
+
+	mustUpload(t, store, repo, "deleted.jpg", "gone soon")
+	if _, err := store.DeleteImage(storage.ImagesInfo{Filename: "deleted.jpg"}, repo); err != nil {
+		t.Fatalf("DeleteImage: %v", err)
+	}
+	_, err = store.GetImage("deleted.jpg", repo)
+	wantErr(t, "GetImage of a deleted image", err, storage.ErrImgNotFound)
+
+	for _, name := range []string{"", "../escape.jpg", "dir/a.jpg", ".hidden"} {
+		_, err := store.SaveNewImage(strings.NewReader("x"), storage.ImagesInfo{Filename: name, CreatedAt: time.Now()}, repo)
+		wantErr(t, fmt.Sprintf("SaveNewImage(%q)", name), err, storage.ErrInvalidName)
+	}
+}
+
+func testSharedContent(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB) {
+	data := "the same bytes"
+	mustUpload(t, store, repo, "one.txt", data)
+
+	// Known content is saved under another name without sending it again.
+	known := storage.ImagesInfo{Filename: "two.txt", Checksum: checksum(data), Size: int64(len(data)), CreatedAt: time.Now()}
+	_, ok, err := store.SaveKnownImage(known, repo)
+	if err != nil || !ok {
+		t.Fatalf("SaveKnownImage = %v, %v, want the content found", ok, err)
+	}
+	wantImage(t, store, repo, "two.txt", data)
+
+	unknown := storage.ImagesInfo{Filename: "three.txt", Checksum: checksum("other"), Size: 5, CreatedAt: time.Now()}
+	_, ok, err = store.SaveKnownImage(unknown, repo)
+	if err != nil || ok {
+		t.Errorf("SaveKnownImage of unknown content = %v, %v, want it not found", ok, err)
+	}
+
+	refs, err := repo.ContentRefs([]string{checksum(data)})
+	if err != nil || refs[checksum(data)] != 2 {
+		t.Errorf("shared content has %v references, %v, want 2", refs, err)
+	}
+
+	// Content still referred to survives garbage collection.
+	if _, err := store.CollectGarbage(repo, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("CollectGarbage: %v", err)
+	}
+	wantImage(t, store, repo, "one.txt", data)
+}
+
+func testUnicodeImageNames(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB) {
+	for _, name := range []string{"фото.jpg", "写真 猫.png", "emoji 😀.gif"} {
+		mustUpload(t, store, repo, name, name)
+		wantImage(t, store, repo, name, name)
+	}
+
+	// Names are normalized, so an image saved with a decomposed é is found with a precomposed one.
+	mustUpload(t, store, repo, "cafe\u0301.jpg", "coffee")
+	wantImage(t, store, repo, "caf\u00e9.jpg", "coffee")
+
+	renamed, err := store.RenameImage(storage.ImagesInfo{Filename: "фото.jpg"}, "снимок.jpg", storage.ConflictFail, repo)
+	if err != nil || renamed.Filename != "снимок.jpg" {
+		t.Fatalf("RenameImage = %+v, %v", renamed, err)
+	}
+	wantImage(t, store, repo, "снимок.jpg", "фото.jpg")
+}
+
+func testNamespaces(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB) {
+	upload := func(namespace, filename, data string) {
+		t.Helper()
+		image := storage.ImagesInfo{Filename: filename, Namespace: namespace, CreatedAt: time.Now()}
+		if _, err := store.SaveNewImage(strings.NewReader(data), image, repo); err != nil {
+			t.Fatalf("SaveNewImage(%s): %v", filename, err)
+		}
+	}
+	upload("alice", "a.jpg", "alice's first")
+	upload("alice", "a.jpg", "alice's")
+	upload("bob", "b.jpg", "bob's")
+
+	// Bob can neither change alice's image nor put anything in its place.
+	alice := storage.ImagesInfo{Filename: "a.jpg", Namespace: "bob"}
+	_, err := store.DeleteImage(alice, repo)
+	wantErr(t, "DeleteImage", err, storage.ErrOtherNamespace)
+	_, err = store.RenameImage(alice, "z.jpg", storage.ConflictFail, repo)
+	wantErr(t, "RenameImage", err, storage.ErrOtherNamespace)
+	_, err = store.CopyImage(alice, "z.jpg", storage.ConflictFail, repo)
+	wantErr(t, "CopyImage", err, storage.ErrOtherNamespace)
+	_, err = store.RestoreVersion(alice, 1, repo)
+	wantErr(t, "RestoreVersion", err, storage.ErrOtherNamespace)
+
+	bob := storage.ImagesInfo{Filename: "b.jpg", Namespace: "bob"}
+	_, err = store.RenameImage(bob, "a.jpg", storage.ConflictOverwrite, repo)
+	wantErr(t, "RenameImage over alice's image", err, storage.ErrOtherNamespace)
+	_, err = store.CopyImage(bob, "a.jpg", storage.ConflictOverwrite, repo)
+	wantErr(t, "CopyImage over alice's image", err, storage.ErrOtherNamespace)
+	wantImage(t, store, repo, "a.jpg", "alice's")
+	wantImage(t, store, repo, "b.jpg", "bob's")
+
+	// A copy is charged to the one who made it.
+	copied, err := store.CopyImage(bob, "c.jpg", storage.ConflictFail, repo)
+	if err != nil || copied.Namespace != "bob" {
+		t.Fatalf("CopyImage = %+v, %v, want a copy in bob's namespace", copied, err)
+	}
+	for namespace, want := range map[string]storage.Usage{"alice": {Bytes: 7, Files: 1}, "bob": {Bytes: 10, Files: 2}} {
+		if got, err := repo.GetUsage(namespace); err != nil || got != want {
+			t.Errorf("usage of %s = %+v, %v, want %+v", namespace, got, err, want)
+		}
+	}
+
+	if _, err := store.DeleteImage(storage.ImagesInfo{Filename: "a.jpg", Namespace: "alice"}, repo); err != nil {
+		t.Errorf("alice cannot delete her own image: %v", err)
+	}
+}
+
+func testConcurrentImageWriters(t *testing.T, store storage.ImageProcessor, repo storage.ImageDB) {
+	const writers = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			own := storage.ImagesInfo{Filename: fmt.Sprintf("own %d.txt", i), CreatedAt: time.Now()}
+			_, err := store.SaveNewImage(strings.NewReader(fmt.Sprintf("own %d", i)), own, repo)
+			errs <- err
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			shared := storage.ImagesInfo{Filename: "shared.txt", CreatedAt: time.Now()}
+			_, err := store.SaveNewImage(strings.NewReader(fmt.Sprintf("shared %d", i)), shared, repo)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent SaveNewImage: %v", err)
+		}
+	}
+
+	for i := 0; i < writers; i++ {
+		wantImage(t, store, repo, fmt.Sprintf("own %d.txt", i), fmt.Sprintf("own %d", i))
+	}
+
+	// The shared image holds whichever write came last, whole.
+	got, err := store.GetImage("shared.txt", repo)
+	if err != nil {
+		t.Fatalf("GetImage: %v", err)
+	}
+	var last int
+	if _, err := fmt.Sscanf(string(got), "shared %d", &last); err != nil || string(got) != fmt.Sprintf("shared %d", last) {
+		t.Errorf("shared.txt holds %q, not one of the writes", got)
+	}
+
+	records, err := store.ImagesView(repo)
+	if err != nil || len(records) != writers+1 {
+		t.Errorf("ImagesView lists %d images, %v, want %d", len(records), err, writers+1)
+	}
+}
@@ -0,0 +1,108 @@
+// Package storagetest checks that implementations of storage.ImageDB and
+// storage.ImageProcessor behave the way the server relies on. A test of an implementation
+// hands TestImageDB or TestImageProcessor a function opening a fresh, empty instance:
+//
+//	func TestBoltDB(t *testing.T) {
+//		storagetest.TestImageDB(t, storagetest.Bolt)
+//	}
+package storagetest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Niiazgulov/tages.git/internal/storage"
+	"github.com/Niiazgulov/tages.git/internal/storage/blob"
+	"github.com/google/uuid"
+)
+
+// OpenDB returns an ImageDB holding no records. It is closed when t ends.
+type OpenDB func(t *testing.T) storage.ImageDB
+
+// OpenStore returns an ImageProcessor holding no images together with the ImageDB it keeps
+// the records in.
+type OpenStore func(t *testing.T) (storage.ImageProcessor, storage.ImageDB)
+
+// Bolt opens a BoltDB in a file of its own under t.TempDir.
+func Bolt(t *testing.T) storage.ImageDB {
+	t.Helper()
+
+	repo, err := storage.NewBoltDB(t.TempDir() + "/tages.db")
+	if err != nil {
+		t.Fatalf("cannot open bolt DB: %v", err)
+	}
+	t.Cleanup(repo.Close)
+
+	return repo
+}
+
+// MemoryStore opens image stores keeping content in memory and records in the DBs open returns.
+func MemoryStore(open OpenDB) OpenStore {
+	return func(t *testing.T) (storage.ImageProcessor, storage.ImageDB) {
+		return storage.NewImageStore(blob.NewMemory(), t.TempDir()), open(t)
+	}
+}
+
+// DiskStore opens image stores keeping content as files in a folder under t.TempDir and
+// records in the DBs open returns.
+func DiskStore(open OpenDB) OpenStore {
+	return func(t *testing.T) (storage.ImageProcessor, storage.ImageDB) {
+		return storage.NewDiskImageStore(t.TempDir()), open(t)
+	}
+}
+
+// checksum returns the SHA-256 of data as stored in image records.
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecord returns the record of a new image named filename holding data.
+func newRecord(filename, data string) storage.ImagesInfo {
+	now := time.Now()
+	return storage.ImagesInfo{
+		ImageId:     uuid.NewString(),
+		Filename:    filename,
+		CreatedAt:   now,
+		ChangedAt:   now,
+		Tags:        []string{"test"},
+		Size:        int64(len(data)),
+		ContentType: "text/plain; charset=utf-8",
+		Checksum:    checksum(data),
+	}
+}
+
+// wantErr fails t unless err is target.
+func wantErr(t *testing.T, what string, err, target error) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Errorf("%s: got error %v, want %v", what, err, target)
+	}
+}
+
+// filenames returns the filenames of records in order.
+func filenames(records []storage.ImagesInfo) []string {
+	names := make([]string, 0, len(records))
+	for _, record := range records {
+		names = append(names, record.Filename)
+	}
+
+	return names
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload session not found")
+	ErrUploadOffset   = errors.New("upload offset is beyond the received data")
+)
+
+// partialFolder holds the data of unfinished upload sessions inside the image folder
+// of NewDiskImageStore.
+const partialFolder = ".partial"
+
+// UploadSession tracks an upload that may span several streams.
+type UploadSession struct {
+	UploadID    string
+	Filename    string
+	Size        int64
+	ContentType string
+	Checksum    string
+	Tags        []string
+	Namespace   string
+	Received    int64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (store *ImageStore) partialPath(uploadID string) string {
+	return strings.Join([]string{store.staging, uploadID}, "/")
+}
+
+// WritePartial writes data to the partial file of an upload session starting at offset,
+// dropping anything the file held past that point. It returns the new size of the file,
+// which also counts the bytes written before a failure.
+func (store *ImageStore) WritePartial(uploadID string, offset int64, data io.Reader) (int64, error) {
+	err := os.MkdirAll(store.staging, 0o755)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create partial folder: %w", err)
+	}
+
+	file, err := os.OpenFile(store.partialPath(uploadID), os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("cannot open partial file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("cannot stat partial file: %w", err)
+	}
+	if offset > info.Size() {
+		return info.Size(), fmt.Errorf("offset %d, received %d: %w", offset, info.Size(), ErrUploadOffset)
+	}
+	err = file.Truncate(offset)
+	if err != nil {
+		return info.Size(), fmt.Errorf("cannot truncate partial file: %w", err)
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return offset, fmt.Errorf("cannot seek partial file: %w", err)
+	}
+
+	written, copyErr := io.Copy(file, data)
+	err = file.Sync()
+	if copyErr != nil {
+		return offset + written, fmt.Errorf("cannot write partial file: %w", copyErr)
+	}
+	if err != nil {
+		return offset + written, fmt.Errorf("cannot sync partial file: %w", err)
+	}
+
+	return offset + written, nil
+}
+
+// PartialSize returns how many bytes of an upload session are stored.
+func (store *ImageStore) PartialSize(uploadID string) (int64, error) {
+	info, err := os.Stat(store.partialPath(uploadID))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot stat partial file: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+func (store *ImageStore) OpenPartial(uploadID string) (io.ReadCloser, error) {
+	file, err := os.Open(store.partialPath(uploadID))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open partial file: %w", err)
+	}
+
+	return file, nil
+}
+
+func (store *ImageStore) RemovePartial(uploadID string) error {
+	err := os.Remove(store.partialPath(uploadID))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot remove partial file: %w", err)
+	}
+
+	return nil
+}
+
+// ExpireUploadSessions drops upload sessions idle since before together with their partial files.
+func ExpireUploadSessions(store ImageProcessor, repo ImageDB, before time.Time) (int, error) {
+	ids, err := repo.DeleteExpiredUploadSessions(before)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := store.RemovePartial(id); err != nil {
+			return len(ids), err
+		}
+	}
+
+	return len(ids), nil
+}
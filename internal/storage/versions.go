@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrVersionNotFound = errors.New("image version not found")
+
+// ImageVersion is an earlier content of an image, kept when a new one replaced it.
+// CreatedAt is when the content was saved.
+type ImageVersion struct {
+	ImageId     string
+	Version     int
+	Size        int64
+	ContentType string
+	Checksum    string
+	CreatedAt   time.Time
+	ReplacedAt  time.Time
+}
+
+// OpenImageVersion opens a byte range of the given version of an image like OpenImage does.
+// Version zero, or the number of the current version, opens the current content.
+func (store *ImageStore) OpenImageVersion(filename string, version int, offset, length int64, repo ImageDB) (io.ReadCloser, ImageStat, error) {
+	filename, err := CleanFilename(filename)
+	if err != nil {
+		return nil, ImageStat{}, err
+	}
+
+	// Content is never changed in place and garbage collection waits for the lock, so the
+	// record and the content it refers to stay in step.
+	store.mutex.RLock()
+	record, err := repo.GetInfo(ImagesInfo{Filename: filename})
+	if err != nil {
+		store.mutex.RUnlock()
+		return nil, ImageStat{}, err
+	}
+	if version != 0 && version != record.Version {
+		kept, err := repo.GetVersion(record.ImageId, version)
+		if err != nil {
+			store.mutex.RUnlock()
+			return nil, ImageStat{}, err
+		}
+		record.Version = kept.Version
+		record.Size = kept.Size
+		record.ContentType = kept.ContentType
+		record.Checksum = kept.Checksum
+	}
+	if _, ok := cleanChecksum(record.Checksum); !ok {
+		store.mutex.RUnlock()
+		return nil, ImageStat{}, fmt.Errorf("%s has no stored content: %w", filename, ErrImgNotFound)
+	}
+	key := contentKey(record.Checksum)
+	reader, info, err := store.blobs.Get(key, offset, length)
+	store.mutex.RUnlock()
+	if err != nil {
+		return nil, ImageStat{}, blobError(filename, err)
+	}
+
+	if length == 0 || offset+length > info.Size {
+		length = info.Size - offset
+	}
+	stat := ImageStat{
+		Filename:    filename,
+		Size:        info.Size,
+		ContentType: record.ContentType,
+		Checksum:    record.Checksum,
+		Version:     record.Version,
+		Offset:      offset,
+		Length:      length,
+	}
+
+	if stat.ContentType == "" {
+		stat.ContentType, err = store.sniff(key)
+		if err != nil {
+			reader.Close()
+			return nil, ImageStat{}, err
+		}
+	}
+
+	return reader, stat, nil
+}
+
+// RestoreVersion makes an earlier version the current content of an image kept in the Namespace
+// of image. The content it replaces is kept as a version of its own, so a restore can be undone
+// the same way.
+func (store *ImageStore) RestoreVersion(image ImagesInfo, version int, repo ImageDB) (ImagesInfo, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	record, err := repo.GetInfo(image)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	err = sameNamespace(image.Namespace, record)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	if version == record.Version {
+		return record, nil
+	}
+
+	restored, err := repo.GetVersion(record.ImageId, version)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+	err = store.charge(record.Namespace, restored.Size-record.Size, 0, repo)
+	if err != nil {
+		return ImagesInfo{}, err
+	}
+
+	record.Size = restored.Size
+	record.ContentType = restored.ContentType
+	record.Checksum = restored.Checksum
+	record.ChangedAt = time.Now()
+	_, err = repo.UpdateInfo(record)
+	if err != nil {
+		return ImagesInfo{}, fmt.Errorf("cannot save image info to the DB: %w", err)
+	}
+
+	return repo.GetInfo(ImagesInfo{ImageId: record.ImageId})
+}
@@ -19,18 +19,46 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	ImageWorker_UploadImage_FullMethodName   = "/imageworker.ImageWorker/UploadImage"
-	ImageWorker_InformImage_FullMethodName   = "/imageworker.ImageWorker/InformImage"
-	ImageWorker_DownloadImage_FullMethodName = "/imageworker.ImageWorker/DownloadImage"
+	ImageWorker_StartUpload_FullMethodName     = "/imageworker.ImageWorker/StartUpload"
+	ImageWorker_GetUploadStatus_FullMethodName = "/imageworker.ImageWorker/GetUploadStatus"
+	ImageWorker_UploadImage_FullMethodName     = "/imageworker.ImageWorker/UploadImage"
+	ImageWorker_InformImage_FullMethodName     = "/imageworker.ImageWorker/InformImage"
+	ImageWorker_ListImages_FullMethodName      = "/imageworker.ImageWorker/ListImages"
+	ImageWorker_StatImage_FullMethodName       = "/imageworker.ImageWorker/StatImage"
+	ImageWorker_DeleteImage_FullMethodName     = "/imageworker.ImageWorker/DeleteImage"
+	ImageWorker_RenameImage_FullMethodName     = "/imageworker.ImageWorker/RenameImage"
+	ImageWorker_CopyImage_FullMethodName       = "/imageworker.ImageWorker/CopyImage"
+	ImageWorker_ListVersions_FullMethodName    = "/imageworker.ImageWorker/ListVersions"
+	ImageWorker_RestoreVersion_FullMethodName  = "/imageworker.ImageWorker/RestoreVersion"
+	ImageWorker_ListTrash_FullMethodName       = "/imageworker.ImageWorker/ListTrash"
+	ImageWorker_RestoreImage_FullMethodName    = "/imageworker.ImageWorker/RestoreImage"
+	ImageWorker_EmptyTrash_FullMethodName      = "/imageworker.ImageWorker/EmptyTrash"
+	ImageWorker_GetUsage_FullMethodName        = "/imageworker.ImageWorker/GetUsage"
+	ImageWorker_DownloadImage_FullMethodName   = "/imageworker.ImageWorker/DownloadImage"
+	ImageWorker_StreamImage_FullMethodName     = "/imageworker.ImageWorker/StreamImage"
 )
 
 // ImageWorkerClient is the client API for ImageWorker service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ImageWorkerClient interface {
+	StartUpload(ctx context.Context, in *StartUploadRequest, opts ...grpc.CallOption) (*StartUploadResponse, error)
+	GetUploadStatus(ctx context.Context, in *UploadStatusRequest, opts ...grpc.CallOption) (*UploadStatusResponse, error)
 	UploadImage(ctx context.Context, opts ...grpc.CallOption) (ImageWorker_UploadImageClient, error)
 	InformImage(ctx context.Context, opts ...grpc.CallOption) (ImageWorker_InformImageClient, error)
+	ListImages(ctx context.Context, in *ListImagesRequest, opts ...grpc.CallOption) (*ListImagesResponse, error)
+	StatImage(ctx context.Context, in *StatImageRequest, opts ...grpc.CallOption) (*StatImageResponse, error)
+	DeleteImage(ctx context.Context, in *DeleteImageRequest, opts ...grpc.CallOption) (*DeleteImageResponse, error)
+	RenameImage(ctx context.Context, in *RenameImageRequest, opts ...grpc.CallOption) (*RenameImageResponse, error)
+	CopyImage(ctx context.Context, in *CopyImageRequest, opts ...grpc.CallOption) (*CopyImageResponse, error)
+	ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error)
+	RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*RestoreVersionResponse, error)
+	ListTrash(ctx context.Context, in *ListTrashRequest, opts ...grpc.CallOption) (*ListTrashResponse, error)
+	RestoreImage(ctx context.Context, in *RestoreImageRequest, opts ...grpc.CallOption) (*RestoreImageResponse, error)
+	EmptyTrash(ctx context.Context, in *EmptyTrashRequest, opts ...grpc.CallOption) (*EmptyTrashResponse, error)
+	GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error)
 	DownloadImage(ctx context.Context, opts ...grpc.CallOption) (ImageWorker_DownloadImageClient, error)
+	StreamImage(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (ImageWorker_StreamImageClient, error)
 }
 
 type imageWorkerClient struct {
@@ -41,6 +69,24 @@ func NewImageWorkerClient(cc grpc.ClientConnInterface) ImageWorkerClient {
 	return &imageWorkerClient{cc}
 }
 
+func (c *imageWorkerClient) StartUpload(ctx context.Context, in *StartUploadRequest, opts ...grpc.CallOption) (*StartUploadResponse, error) {
+	out := new(StartUploadResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_StartUpload_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) GetUploadStatus(ctx context.Context, in *UploadStatusRequest, opts ...grpc.CallOption) (*UploadStatusResponse, error) {
+	out := new(UploadStatusResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_GetUploadStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *imageWorkerClient) UploadImage(ctx context.Context, opts ...grpc.CallOption) (ImageWorker_UploadImageClient, error) {
 	stream, err := c.cc.NewStream(ctx, &ImageWorker_ServiceDesc.Streams[0], ImageWorker_UploadImage_FullMethodName, opts...)
 	if err != nil {
@@ -109,6 +155,105 @@ func (x *imageWorkerInformImageClient) CloseAndRecv() (*InformResponse, error) {
 	return m, nil
 }
 
+func (c *imageWorkerClient) ListImages(ctx context.Context, in *ListImagesRequest, opts ...grpc.CallOption) (*ListImagesResponse, error) {
+	out := new(ListImagesResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_ListImages_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) StatImage(ctx context.Context, in *StatImageRequest, opts ...grpc.CallOption) (*StatImageResponse, error) {
+	out := new(StatImageResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_StatImage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) DeleteImage(ctx context.Context, in *DeleteImageRequest, opts ...grpc.CallOption) (*DeleteImageResponse, error) {
+	out := new(DeleteImageResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_DeleteImage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) RenameImage(ctx context.Context, in *RenameImageRequest, opts ...grpc.CallOption) (*RenameImageResponse, error) {
+	out := new(RenameImageResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_RenameImage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) CopyImage(ctx context.Context, in *CopyImageRequest, opts ...grpc.CallOption) (*CopyImageResponse, error) {
+	out := new(CopyImageResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_CopyImage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error) {
+	out := new(ListVersionsResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_ListVersions_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*RestoreVersionResponse, error) {
+	out := new(RestoreVersionResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_RestoreVersion_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) ListTrash(ctx context.Context, in *ListTrashRequest, opts ...grpc.CallOption) (*ListTrashResponse, error) {
+	out := new(ListTrashResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_ListTrash_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) RestoreImage(ctx context.Context, in *RestoreImageRequest, opts ...grpc.CallOption) (*RestoreImageResponse, error) {
+	out := new(RestoreImageResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_RestoreImage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) EmptyTrash(ctx context.Context, in *EmptyTrashRequest, opts ...grpc.CallOption) (*EmptyTrashResponse, error) {
+	out := new(EmptyTrashResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_EmptyTrash_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageWorkerClient) GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error) {
+	out := new(GetUsageResponse)
+	err := c.cc.Invoke(ctx, ImageWorker_GetUsage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *imageWorkerClient) DownloadImage(ctx context.Context, opts ...grpc.CallOption) (ImageWorker_DownloadImageClient, error) {
 	stream, err := c.cc.NewStream(ctx, &ImageWorker_ServiceDesc.Streams[2], ImageWorker_DownloadImage_FullMethodName, opts...)
 	if err != nil {
@@ -143,13 +288,59 @@ func (x *imageWorkerDownloadImageClient) CloseAndRecv() (*DownloadResponse, erro
 	return m, nil
 }
 
+func (c *imageWorkerClient) StreamImage(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (ImageWorker_StreamImageClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ImageWorker_ServiceDesc.Streams[3], ImageWorker_StreamImage_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &imageWorkerStreamImageClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ImageWorker_StreamImageClient interface {
+	Recv() (*DownloadChunk, error)
+	grpc.ClientStream
+}
+
+type imageWorkerStreamImageClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageWorkerStreamImageClient) Recv() (*DownloadChunk, error) {
+	m := new(DownloadChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ImageWorkerServer is the server API for ImageWorker service.
 // All implementations must embed UnimplementedImageWorkerServer
 // for forward compatibility
 type ImageWorkerServer interface {
+	StartUpload(context.Context, *StartUploadRequest) (*StartUploadResponse, error)
+	GetUploadStatus(context.Context, *UploadStatusRequest) (*UploadStatusResponse, error)
 	UploadImage(ImageWorker_UploadImageServer) error
 	InformImage(ImageWorker_InformImageServer) error
+	ListImages(context.Context, *ListImagesRequest) (*ListImagesResponse, error)
+	StatImage(context.Context, *StatImageRequest) (*StatImageResponse, error)
+	DeleteImage(context.Context, *DeleteImageRequest) (*DeleteImageResponse, error)
+	RenameImage(context.Context, *RenameImageRequest) (*RenameImageResponse, error)
+	CopyImage(context.Context, *CopyImageRequest) (*CopyImageResponse, error)
+	ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error)
+	RestoreVersion(context.Context, *RestoreVersionRequest) (*RestoreVersionResponse, error)
+	ListTrash(context.Context, *ListTrashRequest) (*ListTrashResponse, error)
+	RestoreImage(context.Context, *RestoreImageRequest) (*RestoreImageResponse, error)
+	EmptyTrash(context.Context, *EmptyTrashRequest) (*EmptyTrashResponse, error)
+	GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error)
 	DownloadImage(ImageWorker_DownloadImageServer) error
+	StreamImage(*DownloadRequest, ImageWorker_StreamImageServer) error
 	mustEmbedUnimplementedImageWorkerServer()
 }
 
@@ -157,15 +348,57 @@ type ImageWorkerServer interface {
 type UnimplementedImageWorkerServer struct {
 }
 
+func (UnimplementedImageWorkerServer) StartUpload(context.Context, *StartUploadRequest) (*StartUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartUpload not implemented")
+}
+func (UnimplementedImageWorkerServer) GetUploadStatus(context.Context, *UploadStatusRequest) (*UploadStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUploadStatus not implemented")
+}
 func (UnimplementedImageWorkerServer) UploadImage(ImageWorker_UploadImageServer) error {
 	return status.Errorf(codes.Unimplemented, "method UploadImage not implemented")
 }
 func (UnimplementedImageWorkerServer) InformImage(ImageWorker_InformImageServer) error {
 	return status.Errorf(codes.Unimplemented, "method InformImage not implemented")
 }
+func (UnimplementedImageWorkerServer) ListImages(context.Context, *ListImagesRequest) (*ListImagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListImages not implemented")
+}
+func (UnimplementedImageWorkerServer) StatImage(context.Context, *StatImageRequest) (*StatImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StatImage not implemented")
+}
+func (UnimplementedImageWorkerServer) DeleteImage(context.Context, *DeleteImageRequest) (*DeleteImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteImage not implemented")
+}
+func (UnimplementedImageWorkerServer) RenameImage(context.Context, *RenameImageRequest) (*RenameImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenameImage not implemented")
+}
+func (UnimplementedImageWorkerServer) CopyImage(context.Context, *CopyImageRequest) (*CopyImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CopyImage not implemented")
+}
+func (UnimplementedImageWorkerServer) ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (UnimplementedImageWorkerServer) RestoreVersion(context.Context, *RestoreVersionRequest) (*RestoreVersionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreVersion not implemented")
+}
+func (UnimplementedImageWorkerServer) ListTrash(context.Context, *ListTrashRequest) (*ListTrashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTrash not implemented")
+}
+func (UnimplementedImageWorkerServer) RestoreImage(context.Context, *RestoreImageRequest) (*RestoreImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreImage not implemented")
+}
+func (UnimplementedImageWorkerServer) EmptyTrash(context.Context, *EmptyTrashRequest) (*EmptyTrashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EmptyTrash not implemented")
+}
+func (UnimplementedImageWorkerServer) GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
 func (UnimplementedImageWorkerServer) DownloadImage(ImageWorker_DownloadImageServer) error {
 	return status.Errorf(codes.Unimplemented, "method DownloadImage not implemented")
 }
+func (UnimplementedImageWorkerServer) StreamImage(*DownloadRequest, ImageWorker_StreamImageServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamImage not implemented")
+}
 func (UnimplementedImageWorkerServer) mustEmbedUnimplementedImageWorkerServer() {}
 
 // UnsafeImageWorkerServer may be embedded to opt out of forward compatibility for this service.
@@ -179,6 +412,42 @@ func RegisterImageWorkerServer(s grpc.ServiceRegistrar, srv ImageWorkerServer) {
 	s.RegisterService(&ImageWorker_ServiceDesc, srv)
 }
 
+func _ImageWorker_StartUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).StartUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_StartUpload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).StartUpload(ctx, req.(*StartUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_GetUploadStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).GetUploadStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_GetUploadStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).GetUploadStatus(ctx, req.(*UploadStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _ImageWorker_UploadImage_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(ImageWorkerServer).UploadImage(&imageWorkerUploadImageServer{stream})
 }
@@ -231,6 +500,204 @@ func (x *imageWorkerInformImageServer) Recv() (*InformRequest, error) {
 	return m, nil
 }
 
+func _ImageWorker_ListImages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListImagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).ListImages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_ListImages_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).ListImages(ctx, req.(*ListImagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_StatImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).StatImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_StatImage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).StatImage(ctx, req.(*StatImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_DeleteImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).DeleteImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_DeleteImage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).DeleteImage(ctx, req.(*DeleteImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_RenameImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).RenameImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_RenameImage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).RenameImage(ctx, req.(*RenameImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_CopyImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CopyImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).CopyImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_CopyImage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).CopyImage(ctx, req.(*CopyImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_ListVersions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).ListVersions(ctx, req.(*ListVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_RestoreVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).RestoreVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_RestoreVersion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).RestoreVersion(ctx, req.(*RestoreVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_ListTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTrashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).ListTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_ListTrash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).ListTrash(ctx, req.(*ListTrashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_RestoreImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).RestoreImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_RestoreImage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).RestoreImage(ctx, req.(*RestoreImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_EmptyTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmptyTrashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).EmptyTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_EmptyTrash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).EmptyTrash(ctx, req.(*EmptyTrashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageWorker_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageWorkerServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageWorker_GetUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageWorkerServer).GetUsage(ctx, req.(*GetUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _ImageWorker_DownloadImage_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(ImageWorkerServer).DownloadImage(&imageWorkerDownloadImageServer{stream})
 }
@@ -257,13 +724,87 @@ func (x *imageWorkerDownloadImageServer) Recv() (*DownloadRequest, error) {
 	return m, nil
 }
 
+func _ImageWorker_StreamImage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ImageWorkerServer).StreamImage(m, &imageWorkerStreamImageServer{stream})
+}
+
+type ImageWorker_StreamImageServer interface {
+	Send(*DownloadChunk) error
+	grpc.ServerStream
+}
+
+type imageWorkerStreamImageServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageWorkerStreamImageServer) Send(m *DownloadChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // ImageWorker_ServiceDesc is the grpc.ServiceDesc for ImageWorker service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var ImageWorker_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "imageworker.ImageWorker",
 	HandlerType: (*ImageWorkerServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartUpload",
+			Handler:    _ImageWorker_StartUpload_Handler,
+		},
+		{
+			MethodName: "GetUploadStatus",
+			Handler:    _ImageWorker_GetUploadStatus_Handler,
+		},
+		{
+			MethodName: "ListImages",
+			Handler:    _ImageWorker_ListImages_Handler,
+		},
+		{
+			MethodName: "StatImage",
+			Handler:    _ImageWorker_StatImage_Handler,
+		},
+		{
+			MethodName: "DeleteImage",
+			Handler:    _ImageWorker_DeleteImage_Handler,
+		},
+		{
+			MethodName: "RenameImage",
+			Handler:    _ImageWorker_RenameImage_Handler,
+		},
+		{
+			MethodName: "CopyImage",
+			Handler:    _ImageWorker_CopyImage_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _ImageWorker_ListVersions_Handler,
+		},
+		{
+			MethodName: "RestoreVersion",
+			Handler:    _ImageWorker_RestoreVersion_Handler,
+		},
+		{
+			MethodName: "ListTrash",
+			Handler:    _ImageWorker_ListTrash_Handler,
+		},
+		{
+			MethodName: "RestoreImage",
+			Handler:    _ImageWorker_RestoreImage_Handler,
+		},
+		{
+			MethodName: "EmptyTrash",
+			Handler:    _ImageWorker_EmptyTrash_Handler,
+		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _ImageWorker_GetUsage_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "UploadImage",
@@ -280,6 +821,11 @@ var ImageWorker_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _ImageWorker_DownloadImage_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "StreamImage",
+			Handler:       _ImageWorker_StreamImage_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "tages.proto",
 }
@@ -0,0 +1,10 @@
+// Package protos holds what clients and the server agree on besides the messages generated
+// from tages.proto.
+package protos
+
+// ClientIDKey is the metadata key a client may set to be identified independently of its address.
+// The server only takes the id if the client also sends the key proving it under ClientKeyKey.
+const ClientIDKey = "x-client-id"
+
+// ClientKeyKey is the metadata key of the secret proving a client id.
+const ClientKeyKey = "x-client-key"